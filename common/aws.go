@@ -1,7 +1,6 @@
 package common
 
 import (
-	"encoding/json"
 	"errors"
 	"os"
 
@@ -10,60 +9,58 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+// sqsActionAttribute is the message attribute that carries the action of a
+// message. Consumers dispatch on it.
+const sqsActionAttribute = "Key"
+
 func SQSDeliverMessage(queueName, action string, payload interface{}, delay int) error {
+	return SQSDeliverMessageWithOptions(queueName, action, payload, PublishOptions{DelaySeconds: delay})
+}
+
+// InitSQS makes SQSDeliverMessage and consumers use an SQS backend built on
+// sess. A nil session is created from the environment on first use.
+func InitSQS(sess *session.Session) {
+	SetDefaultQueue(NewSQSQueue(sess))
+}
+
+func newSQSClient(sess *session.Session) (*sqs.SQS, error) {
+	sess, err := envSession(sess)
+	if err != nil {
+		return nil, err
+	}
+	// SQSQueue retries requests itself, see RetryPolicy
+	return sqs.New(sess, awsConfig("SQS_ENDPOINT").WithMaxRetries(0)), nil
+}
+
+// envSession returns sess, or a new session if it is nil, in which case AWS
+// credentials must be set unless USE_EC2_PARAMETERS is.
+func envSession(sess *session.Session) (*session.Session, error) {
+	if sess != nil {
+		return sess, nil
+	}
 	if os.Getenv("USE_EC2_PARAMETERS") == "" {
 		if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
-			return errors.New("AWS_ACCESS_KEY_ID must be set")
+			return nil, errors.New("AWS_ACCESS_KEY_ID must be set")
 		}
 		if os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
-			return errors.New("AWS_SECRET_ACCESS_KEY must be set")
+			return nil, errors.New("AWS_SECRET_ACCESS_KEY must be set")
 		}
 	}
+	return session.New(), nil
+}
 
+// awsConfig returns a config with the region from AWS_REGION and the endpoint
+// from the given environment variable, if set.
+func awsConfig(endpointEnv string) *aws.Config {
 	config := aws.NewConfig()
 	region := os.Getenv("AWS_REGION")
 	if region == "" {
 		region = "us-east-1"
 	}
 	config = config.WithRegion(region)
-	endpoint := os.Getenv("SQS_ENDPOINT")
+	endpoint := os.Getenv(endpointEnv)
 	if endpoint != "" {
 		config = config.WithEndpoint(endpoint)
 	}
-	svc := sqs.New(session.New(), config)
-
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	getQueueURLRequest := &sqs.GetQueueUrlInput{
-		QueueName: aws.String(queueName),
-	}
-	getQueueURLOutput, err := svc.GetQueueUrl(getQueueURLRequest)
-	if err != nil {
-		return err
-	}
-
-	sendMessageInput := &sqs.SendMessageInput{
-		MessageBody: aws.String(string(b[:])),
-		QueueUrl:    getQueueURLOutput.QueueUrl,
-		MessageAttributes: map[string]*sqs.MessageAttributeValue{
-			"Key": {
-				DataType:    aws.String("String"),
-				StringValue: aws.String(action),
-			},
-		},
-	}
-
-	if delay > 0 {
-		delay64 := int64(delay)
-		sendMessageInput.DelaySeconds = &delay64
-	}
-
-	if _, err := svc.SendMessage(sendMessageInput); err != nil {
-		return err
-	}
-
-	return nil
+	return config
 }
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BatchSender is implemented by queues that can send several messages in one
+// call.
+type BatchSender interface {
+	// SendBatch sends msgs, returning a *BatchError listing the messages
+	// that could not be sent.
+	SendBatch(ctx context.Context, queueName string, msgs []*SQSMessage) error
+}
+
+// BatchFailure is a message of a batch that could not be sent. Index is its
+// position in the slice passed to SendBatch.
+type BatchFailure struct {
+	Index int
+	Err   error
+}
+
+// BatchError is returned when some of the messages of a batch could not be
+// sent. Messages not listed were sent successfully.
+type BatchError struct {
+	Failures []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("message %d: %v", f.Index, f.Err))
+	}
+	return fmt.Sprintf("failed to send %d messages: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// SendBatch sends msgs with q's SendBatch if it has one, or one at a time
+// otherwise.
+func SendBatch(ctx context.Context, q Queue, queueName string, msgs []*SQSMessage) error {
+	if b, ok := q.(BatchSender); ok {
+		return b.SendBatch(ctx, queueName, msgs)
+	}
+
+	batchErr := &BatchError{}
+	for i, msg := range msgs {
+		if err := q.Send(ctx, queueName, msg); err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Failures) > 0 {
+		return batchErr
+	}
+	return nil
+}
+
+// SQSDeliverMessageBatch is the batch version of SQSDeliverMessage. All
+// payloads are sent with the same action and delay. On partial failure it
+// returns a *BatchError whose indexes refer to payloads.
+func SQSDeliverMessageBatch(queueName, action string, payloads []interface{}, delay int) error {
+	return SQSDeliverMessageBatchWithOptions(queueName, action, payloads, PublishOptions{DelaySeconds: delay})
+}
+
+// SQSDeliverMessageBatchWithOptions is the batch version of
+// SQSDeliverMessageWithOptions. All payloads are sent with the same options.
+func SQSDeliverMessageBatchWithOptions(queueName, action string, payloads []interface{}, opts PublishOptions) error {
+	return SQSDeliverMessageBatchContext(context.Background(), queueName, action, payloads, opts)
+}
+
+// SQSDeliverMessageBatchContext is SQSDeliverMessageBatchWithOptions with a
+// context.
+func SQSDeliverMessageBatchContext(ctx context.Context, queueName, action string, payloads []interface{}, opts PublishOptions) error {
+	batchErr := &BatchError{}
+	msgs := make([]*SQSMessage, 0, len(payloads))
+	indexes := make([]int, 0, len(payloads))
+	for i, payload := range payloads {
+		msg, err := newMessage(ctx, action, payload, opts)
+		if err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		msgs = append(msgs, msg)
+		indexes = append(indexes, i)
+	}
+
+	var err error
+	if len(msgs) > 0 {
+		err = SendBatch(ctx, DefaultQueue(), queueName, msgs)
+	}
+	return remapBatchError(batchErr, err, indexes)
+}
+
+// remapBatchError merges err, returned by sending a subset of a batch, into
+// batchErr, which holds the failures of the messages left out of the subset.
+// indexes maps positions in the subset to positions in the batch. An err that
+// is not a *BatchError is returned as is. Otherwise batchErr is returned, with
+// its failures sorted by index, if any message failed.
+func remapBatchError(batchErr *BatchError, err error, indexes []int) error {
+	if e, ok := err.(*BatchError); ok {
+		for _, f := range e.Failures {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: indexes[f.Index], Err: f.Err})
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if len(batchErr.Failures) > 0 {
+		sort.Slice(batchErr.Failures, func(i, j int) bool {
+			return batchErr.Failures[i].Index < batchErr.Failures[j].Index
+		})
+		return batchErr
+	}
+	return nil
+}
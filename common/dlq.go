@@ -0,0 +1,165 @@
+package common
+
+import (
+	"context"
+	"log"
+)
+
+// dlqVisibilityTimeout hides messages already seen while a DLQ is scanned,
+// so that each receive returns new ones.
+const dlqVisibilityTimeout = 60
+
+// DLQOptions select the dead-letter queue messages PeekDLQ and RedriveDLQ
+// operate on.
+type DLQOptions struct {
+	// Queue is the backend. Defaults to DefaultQueue().
+	Queue Queue
+	// Actions restricts the messages to these actions. Empty means all.
+	Actions []string
+	// Filter, if set, must return true for a message to be selected.
+	Filter func(msg *SQSMessage) bool
+	// MaxMessages stops the scan after this many messages were received,
+	// selected or not. Defaults to 1000.
+	MaxMessages int
+}
+
+func (o DLQOptions) withDefaults() DLQOptions {
+	if o.Queue == nil {
+		o.Queue = DefaultQueue()
+	}
+	if o.MaxMessages <= 0 {
+		o.MaxMessages = 1000
+	}
+	return o
+}
+
+func (o DLQOptions) selects(msg *SQSMessage) bool {
+	if len(o.Actions) > 0 {
+		found := false
+		for _, action := range o.Actions {
+			if msg.Action == action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return o.Filter == nil || o.Filter(msg)
+}
+
+// PeekDLQ returns the selected messages of a dead-letter queue without
+// removing them. Use SQSMessage.Unmarshal and SQSMessage.Envelope to decode
+// them. Peeking increments the receive count of every message scanned.
+func PeekDLQ(ctx context.Context, dlqName string, opts DLQOptions) ([]*SQSMessage, error) {
+	opts = opts.withDefaults()
+
+	var selected []*SQSMessage
+	err := scanDLQ(ctx, dlqName, opts, func(msg *SQSMessage) (bool, error) {
+		if opts.selects(msg) {
+			selected = append(selected, msg)
+		}
+		return false, nil
+	})
+	return selected, err
+}
+
+// RedriveDLQ sends the selected messages of a dead-letter queue back to
+// sourceQueueName with their action, payload and envelope, and deletes them
+// from the dead-letter queue. It returns the number of messages redriven.
+func RedriveDLQ(ctx context.Context, dlqName, sourceQueueName string, opts DLQOptions) (int, error) {
+	opts = opts.withDefaults()
+
+	redriven := 0
+	err := scanDLQ(ctx, dlqName, opts, func(msg *SQSMessage) (bool, error) {
+		if !opts.selects(msg) {
+			return false, nil
+		}
+
+		m := &SQSMessage{
+			Action:     msg.Action,
+			Body:       msg.Body,
+			Attributes: copyAttributes(msg.Attributes),
+		}
+		if IsFIFOQueue(sourceQueueName) {
+			m.GroupID = msg.GroupID
+			// redriving the same message twice is deduplicated
+			m.DeduplicationID = msg.ID
+		}
+		if err := opts.Queue.Send(ctx, sourceQueueName, m); err != nil {
+			return false, err
+		}
+		redriven++
+		return true, nil
+	})
+	return redriven, err
+}
+
+// scanDLQ receives messages until the queue is drained or MaxMessages were
+// received, calling fn for each message once. Messages for which fn returns
+// true are deleted, the others are made visible again when the scan ends.
+func scanDLQ(ctx context.Context, dlqName string, opts DLQOptions, fn func(msg *SQSMessage) (bool, error)) error {
+	seen := map[string]bool{}
+	var received []*SQSMessage
+	deleted := map[string]bool{}
+	defer func() {
+		v, ok := opts.Queue.(VisibilityChanger)
+		if !ok {
+			return
+		}
+		for _, msg := range received {
+			if deleted[msg.ReceiptHandle] {
+				continue
+			}
+			if err := v.ChangeVisibility(context.Background(), dlqName, msg.ReceiptHandle, 0); err != nil {
+				log.Printf("Failed to release message %s on %s: %v", msg.ID, dlqName, err)
+			}
+		}
+	}()
+
+	for len(seen) < opts.MaxMessages {
+		maxMessages := opts.MaxMessages - len(seen)
+		if maxMessages > sqsMaxBatchEntries {
+			maxMessages = sqsMaxBatchEntries
+		}
+		batch, err := opts.Queue.Receive(ctx, dlqName, ReceiveOptions{
+			MaxMessages:       int64(maxMessages),
+			WaitTimeSeconds:   1,
+			VisibilityTimeout: dlqVisibilityTimeout,
+		})
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		received = append(received, batch...)
+
+		fresh := 0
+		for _, msg := range batch {
+			if seen[msg.ID] {
+				continue
+			}
+			seen[msg.ID] = true
+			fresh++
+
+			done, err := fn(msg)
+			if err != nil {
+				return err
+			}
+			if !done {
+				continue
+			}
+			if err := opts.Queue.Delete(ctx, dlqName, msg.ReceiptHandle); err != nil {
+				return err
+			}
+			deleted[msg.ReceiptHandle] = true
+		}
+		if fresh == 0 {
+			// the scan took longer than the visibility timeout and came around
+			return nil
+		}
+	}
+	return nil
+}
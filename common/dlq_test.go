@@ -0,0 +1,48 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeekAndRedriveDLQ(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	require.NoError(t, SQSDeliverMessage("mail_api_dlq", "send", map[string]string{"to": "a@example.com"}, 0))
+	require.NoError(t, SQSDeliverMessage("mail_api_dlq", "send_raw", map[string]string{"to": "b@example.com"}, 0))
+	require.NoError(t, SQSDeliverMessage("mail_api_dlq", "send", map[string]string{"to": "c@example.com"}, 0))
+
+	peeked, err := PeekDLQ(ctx, "mail_api_dlq", DLQOptions{Actions: []string{"send"}})
+	require.NoError(t, err)
+	require.Len(t, peeked, 2)
+	var payload map[string]string
+	require.NoError(t, peeked[0].Unmarshal(&payload))
+	assert.Equal(t, "a@example.com", payload["to"])
+	assert.NotNil(t, peeked[0].Envelope())
+	assert.Equal(t, 3, q.Len("mail_api_dlq"))
+
+	n, err := RedriveDLQ(ctx, "mail_api_dlq", "mail_api", DLQOptions{
+		Filter: func(msg *SQSMessage) bool {
+			var p map[string]string
+			return msg.Unmarshal(&p) == nil && p["to"] != "c@example.com"
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, q.Len("mail_api_dlq"))
+
+	redriven := q.Sent("mail_api")
+	require.Len(t, redriven, 2)
+	assert.Equal(t, peeked[0].Envelope().MessageID, redriven[0].Envelope().MessageID)
+
+	// released messages are visible again
+	remaining, err := q.Receive(ctx, "mail_api_dlq", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	assert.Len(t, remaining, 1)
+}
@@ -0,0 +1,252 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/replicatedcom/saaskit/crypto"
+)
+
+// encodingAttribute lists the encodings applied to a message body, in the
+// order they were applied, separated by commas. Encryption is recorded as
+// "aes:<key id>".
+const encodingAttribute = "ContentEncoding"
+
+const (
+	encodingGzip      = "gzip"
+	encodingAESPrefix = "aes:"
+)
+
+// maxDecodedBodySize is the largest body in bytes a received message may
+// decompress to. It bounds the memory a small, highly compressed message can
+// make a consumer allocate, while leaving room for offloaded bodies.
+const maxDecodedBodySize = 64 * 1024 * 1024
+
+// EncodingOptions configure an EncodingQueue.
+type EncodingOptions struct {
+	// Compress gzips message bodies of at least CompressThreshold bytes.
+	Compress bool
+	// CompressThreshold is the body size in bytes under which bodies are not
+	// compressed. Defaults to 1024.
+	CompressThreshold int
+
+	// Keys are the AES keys by key ID. Received messages encrypted with any
+	// of them are decrypted, so keys that were rotated out should be kept
+	// until the messages encrypted with them have expired.
+	Keys map[string][]byte
+	// KeyID is the key new messages are encrypted with. 32 byte keys use
+	// AES-256-GCM with the action as associated data; 16 and 24 byte keys
+	// keep using unauthenticated AES-CFB so that existing deployments work
+	// unchanged, and should be replaced. Empty means sent messages are not
+	// encrypted.
+	KeyID string
+}
+
+// EncodingQueue wraps a Queue to compress and encrypt message bodies on send
+// and to restore them on receive. The encodings are recorded in a message
+// attribute, so messages sent without encoding are received unchanged and
+// publishers can enable it before or after their consumers. The action and
+// envelope attributes are not encoded. Received bodies that decompress to
+// more than 64 MiB are rejected.
+//
+// To combine it with an OffloadQueue, wrap the OffloadQueue so that bodies
+// are encoded before they are offloaded.
+type EncodingQueue struct {
+	queue Queue
+	opts  EncodingOptions
+}
+
+// NewEncodingQueue returns an EncodingQueue wrapping queue. It fails if a key
+// is not a valid AES key or KeyID is not one of the keys.
+func NewEncodingQueue(queue Queue, opts EncodingOptions) (*EncodingQueue, error) {
+	if opts.CompressThreshold <= 0 {
+		opts.CompressThreshold = 1024
+	}
+	for id, key := range opts.Keys {
+		if strings.Contains(id, ",") || id == "" {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %s: invalid AES key size %d", id, len(key))
+		}
+	}
+	if _, ok := opts.Keys[opts.KeyID]; opts.KeyID != "" && !ok {
+		return nil, fmt.Errorf("key %s not found", opts.KeyID)
+	}
+	return &EncodingQueue{queue: queue, opts: opts}, nil
+}
+
+func (q *EncodingQueue) Send(ctx context.Context, queueName string, msg *SQSMessage) error {
+	msg, err := q.encode(msg)
+	if err != nil {
+		return err
+	}
+	return q.queue.Send(ctx, queueName, msg)
+}
+
+func (q *EncodingQueue) SendBatch(ctx context.Context, queueName string, msgs []*SQSMessage) error {
+	batchErr := &BatchError{}
+	encoded := make([]*SQSMessage, 0, len(msgs))
+	indexes := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		m, err := q.encode(msg)
+		if err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		encoded = append(encoded, m)
+		indexes = append(indexes, i)
+	}
+
+	err := SendBatch(ctx, q.queue, queueName, encoded)
+	return remapBatchError(batchErr, err, indexes)
+}
+
+// Receive returns received messages with their bodies decoded. A message that
+// cannot be decoded, for example because its key is unknown, is left on the
+// queue to be redelivered.
+func (q *EncodingQueue) Receive(ctx context.Context, queueName string, opts ReceiveOptions) ([]*SQSMessage, error) {
+	received, err := q.queue.Receive(ctx, queueName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*SQSMessage, 0, len(received))
+	for _, msg := range received {
+		if err := q.decode(msg); err != nil {
+			log.Printf("Failed to decode message %s from %s: %v", msg.ID, queueName, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (q *EncodingQueue) Delete(ctx context.Context, queueName string, receiptHandle string) error {
+	return q.queue.Delete(ctx, queueName, receiptHandle)
+}
+
+// ChangeVisibility forwards to the wrapped queue if it is a
+// VisibilityChanger.
+func (q *EncodingQueue) ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, timeoutSeconds int64) error {
+	return changeVisibility(ctx, q.queue, queueName, receiptHandle, timeoutSeconds)
+}
+
+// encode returns msg unchanged if no encoding applies, or an encoded copy
+// otherwise.
+func (q *EncodingQueue) encode(msg *SQSMessage) (*SQSMessage, error) {
+	if _, ok := msg.Attributes[encodingAttribute]; ok {
+		// already encoded, e.g. when redriven from a DLQ
+		return msg, nil
+	}
+
+	body := msg.Body
+	var encodings []string
+	if q.opts.Compress && len(body) >= q.opts.CompressThreshold {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(body)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.String()
+		encodings = append(encodings, encodingGzip)
+	}
+	if q.opts.KeyID != "" {
+		ciphertext, err := encryptBody(q.opts.Keys[q.opts.KeyID], body, msg.Action)
+		if err != nil {
+			return nil, err
+		}
+		body = ciphertext
+		encodings = append(encodings, encodingAESPrefix+q.opts.KeyID)
+	} else if len(encodings) > 0 {
+		// gzip output is binary, which SQS does not accept
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	if len(encodings) == 0 {
+		return msg, nil
+	}
+
+	m := *msg
+	m.Body = body
+	m.Attributes = copyAttributes(msg.Attributes)
+	if m.Attributes == nil {
+		m.Attributes = map[string]string{}
+	}
+	m.Attributes[encodingAttribute] = strings.Join(encodings, ",")
+	return &m, nil
+}
+
+// decode restores the body of msg in place and removes the encoding
+// attribute.
+func (q *EncodingQueue) decode(msg *SQSMessage) error {
+	value, ok := msg.Attributes[encodingAttribute]
+	if !ok {
+		return nil
+	}
+
+	encodings := strings.Split(value, ",")
+	body := msg.Body
+	last := encodings[len(encodings)-1]
+	if strings.HasPrefix(last, encodingAESPrefix) {
+		id := strings.TrimPrefix(last, encodingAESPrefix)
+		key, ok := q.opts.Keys[id]
+		if !ok {
+			return fmt.Errorf("key %s not found", id)
+		}
+		plaintext, err := crypto.Decrypt(key, body, []byte(msg.Action))
+		if err != nil {
+			return err
+		}
+		body = string(plaintext)
+		encodings = encodings[:len(encodings)-1]
+	} else {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return err
+		}
+		body = string(b)
+	}
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if encodings[i] != encodingGzip {
+			return fmt.Errorf("unsupported encoding %q", encodings[i])
+		}
+		r, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedBodySize+1))
+		if err != nil {
+			return err
+		}
+		if len(b) > maxDecodedBodySize {
+			return fmt.Errorf("decompressed body exceeds %d bytes", maxDecodedBodySize)
+		}
+		body = string(b)
+	}
+
+	msg.Body = body
+	delete(msg.Attributes, encodingAttribute)
+	return nil
+}
+
+// encryptBody encrypts body with AES-256-GCM, authenticating the action, or
+// with AES-CFB for keys too short for it. decode reads both.
+func encryptBody(key []byte, body, action string) (string, error) {
+	if len(key) != 32 {
+		return crypto.AesEncrypt(key, body)
+	}
+	return crypto.Encrypt(key, []byte(body), []byte(action))
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/replicatedcom/saaskit/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingQueueRoundTrip(t *testing.T) {
+	inner := NewMemoryQueue()
+	keys := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}
+	q, err := NewEncodingQueue(inner, EncodingOptions{Compress: true, Keys: keys, KeyID: "k1"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	large := `{"to":"` + strings.Repeat("a", 4096) + `"}`
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "large", Body: large}))
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "small", Body: `{"to":"b"}`}))
+
+	sent := inner.Sent("test-queue")
+	require.Len(t, sent, 2)
+	assert.Equal(t, "gzip,aes:k1", sent[0].Attributes[encodingAttribute])
+	assert.Less(t, len(sent[0].Body), len(large))
+	assert.Equal(t, "aes:k1", sent[1].Attributes[encodingAttribute])
+	assert.NotContains(t, sent[1].Body, "to")
+
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, large, msgs[0].Body)
+	assert.Equal(t, `{"to":"b"}`, msgs[1].Body)
+	assert.NotContains(t, msgs[0].Attributes, encodingAttribute)
+}
+
+func TestEncodingQueueCompressOnly(t *testing.T) {
+	inner := NewMemoryQueue()
+	q, err := NewEncodingQueue(inner, EncodingOptions{Compress: true, CompressThreshold: 1})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: `{"id":1}`}))
+	assert.Equal(t, "gzip", inner.Sent("test-queue")[0].Attributes[encodingAttribute])
+
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, `{"id":1}`, msgs[0].Body)
+}
+
+func TestEncodingQueueDecompressLimit(t *testing.T) {
+	q, err := NewEncodingQueue(NewMemoryQueue(), EncodingOptions{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	chunk := make([]byte, 1024*1024)
+	for n := 0; n <= maxDecodedBodySize; n += len(chunk) {
+		_, err := w.Write(chunk)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	msg := &SQSMessage{
+		Action:     "bomb",
+		Body:       base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Attributes: map[string]string{encodingAttribute: encodingGzip},
+	}
+	assert.Error(t, q.decode(msg))
+}
+
+func TestEncodingQueueKeyRotation(t *testing.T) {
+	inner := NewMemoryQueue()
+	ctx := context.Background()
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 32)
+
+	old, err := NewEncodingQueue(inner, EncodingOptions{Keys: map[string][]byte{"k1": k1}, KeyID: "k1"})
+	require.NoError(t, err)
+	require.NoError(t, old.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "1"}))
+	require.NoError(t, inner.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "plain"}))
+
+	rotated, err := NewEncodingQueue(inner, EncodingOptions{Keys: map[string][]byte{"k1": k1, "k2": k2}, KeyID: "k2"})
+	require.NoError(t, err)
+	require.NoError(t, rotated.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "2"}))
+	assert.Equal(t, "aes:k2", inner.Sent("test-queue")[2].Attributes[encodingAttribute])
+
+	msgs, err := rotated.Receive(ctx, "test-queue", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "1", msgs[0].Body)
+	assert.Equal(t, "plain", msgs[1].Body)
+	assert.Equal(t, "2", msgs[2].Body)
+
+	// a consumer without the new key leaves the message on the queue
+	require.NoError(t, rotated.Send(ctx, "other-queue", &SQSMessage{Action: "a", Body: "3"}))
+	msgs, err = old.Receive(ctx, "other-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	assert.Equal(t, 1, inner.Len("other-queue"))
+}
+
+func TestEncodingQueueAuthenticatesAction(t *testing.T) {
+	inner := NewMemoryQueue()
+	key := bytes.Repeat([]byte{1}, 32)
+	q, err := NewEncodingQueue(inner, EncodingOptions{Keys: map[string][]byte{"k1": key}, KeyID: "k1"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "1"}))
+	m := inner.Sent("test-queue")[0]
+	require.NoError(t, inner.Send(ctx, "other-queue", &SQSMessage{Action: "b", Body: m.Body, Attributes: m.Attributes}))
+	msgs, err := q.Receive(ctx, "other-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// messages encrypted before the switch to AES-GCM are still read
+	legacy, err := crypto.AesEncrypt(key, "2")
+	require.NoError(t, err)
+	require.NoError(t, inner.Send(ctx, "legacy-queue", &SQSMessage{Action: "a", Body: legacy, Attributes: map[string]string{encodingAttribute: "aes:k1"}}))
+	msgs, err = q.Receive(ctx, "legacy-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "2", msgs[0].Body)
+}
+
+func TestNewEncodingQueueValidatesKeys(t *testing.T) {
+	_, err := NewEncodingQueue(NewMemoryQueue(), EncodingOptions{Keys: map[string][]byte{"k1": []byte("short")}})
+	assert.Error(t, err)
+	_, err = NewEncodingQueue(NewMemoryQueue(), EncodingOptions{KeyID: "missing"})
+	assert.Error(t, err)
+}
+
+func TestEncodingQueueShortKeys(t *testing.T) {
+	inner := NewMemoryQueue()
+	key := bytes.Repeat([]byte{1}, 16)
+	q, err := NewEncodingQueue(inner, EncodingOptions{Keys: map[string][]byte{"k1": key}, KeyID: "k1"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "1"}))
+	assert.True(t, crypto.IsLegacyCiphertext(inner.Sent("test-queue")[0].Body))
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "1", msgs[0].Body)
+}
+
+func TestEncodingQueueOffloadsCiphertext(t *testing.T) {
+	inner := NewMemoryQueue()
+	store := NewDirPayloadStore(t.TempDir())
+	keys := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)}
+	q, err := NewEncodingQueue(NewOffloadQueue(inner, store, 1024), EncodingOptions{Keys: keys, KeyID: "k1"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	body := `"` + strings.Repeat("secret", 1000) + `"`
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: body}))
+
+	ref := inner.Sent("test-queue")[0].Attributes[offloadAttribute]
+	stored, err := store.Get(ctx, ref)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "secret")
+
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, body, msgs[0].Body)
+}
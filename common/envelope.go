@@ -0,0 +1,99 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/saaskit/tracing/datadog"
+)
+
+// envelopeAttribute carries the JSON encoded Envelope of a message. It is a
+// single attribute because SQS allows at most 10 per message.
+const envelopeAttribute = "Envelope"
+
+// Envelope is metadata sent along with every message published with the
+// SQSDeliverMessage functions.
+type Envelope struct {
+	// MessageID identifies the message. Unlike the SQS message ID, it is kept
+	// when the message is redriven or re-enqueued.
+	MessageID string `json:"id"`
+	// Producer is the name of the service that sent the message, see
+	// SetProducer.
+	Producer   string    `json:"producer,omitempty"`
+	ProducedAt time.Time `json:"produced_at"`
+	// SchemaVersion is the version of the payload schema, from
+	// PublishOptions.
+	SchemaVersion int `json:"schema_version"`
+	// Trace holds the Datadog propagation headers of the span that was
+	// active when the message was sent.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+var (
+	producer   = os.Getenv("PROJECT_NAME")
+	producerMu sync.RWMutex
+)
+
+// SetProducer sets the producer name written to message envelopes. It
+// defaults to the PROJECT_NAME environment variable.
+func SetProducer(name string) {
+	producerMu.Lock()
+	defer producerMu.Unlock()
+	producer = name
+}
+
+func getProducer() string {
+	producerMu.RLock()
+	defer producerMu.RUnlock()
+	return producer
+}
+
+func newEnvelope(ctx context.Context, schemaVersion int) (*Envelope, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	if schemaVersion <= 0 {
+		schemaVersion = 1
+	}
+
+	env := &Envelope{
+		MessageID:     id,
+		Producer:      getProducer(),
+		ProducedAt:    time.Now().UTC(),
+		SchemaVersion: schemaVersion,
+		Trace:         map[string]string{},
+	}
+	datadog.InjectTextMap(ctx, env.Trace)
+	return env, nil
+}
+
+// Envelope returns the envelope the message was sent with, or nil if it has
+// none, as is the case for messages not sent with the SQSDeliverMessage
+// functions.
+func (m *SQSMessage) Envelope() *Envelope {
+	b, ok := m.Attributes[envelopeAttribute]
+	if !ok {
+		return nil
+	}
+	env := &Envelope{}
+	if err := json.Unmarshal([]byte(b), env); err != nil {
+		return nil
+	}
+	return env
+}
+
+func (m *SQSMessage) setEnvelope(env *Envelope) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if m.Attributes == nil {
+		m.Attributes = map[string]string{}
+	}
+	m.Attributes[envelopeAttribute] = string(b)
+	return nil
+}
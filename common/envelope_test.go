@@ -0,0 +1,38 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+	SetProducer("vendor-api")
+	defer SetProducer("")
+
+	before := time.Now()
+	require.NoError(t, SQSDeliverMessageWithOptions("test-queue", "a", 1, PublishOptions{SchemaVersion: 2}))
+	require.NoError(t, SQSDeliverMessage("test-queue", "a", 2, 0))
+
+	sent := q.Sent("test-queue")
+	require.Len(t, sent, 2)
+
+	env := sent[0].Envelope()
+	require.NotNil(t, env)
+	assert.Len(t, env.MessageID, 32)
+	assert.Equal(t, "vendor-api", env.Producer)
+	assert.Equal(t, 2, env.SchemaVersion)
+	assert.False(t, env.ProducedAt.Before(before.Truncate(time.Second)))
+
+	env2 := sent[1].Envelope()
+	require.NotNil(t, env2)
+	assert.Equal(t, 1, env2.SchemaVersion)
+	assert.NotEqual(t, env.MessageID, env2.MessageID)
+
+	assert.Nil(t, (&SQSMessage{Body: "{}"}).Envelope())
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ErrQueueNotFound is returned when the queue does not exist.
+type ErrQueueNotFound struct {
+	QueueName string
+	err       error
+}
+
+func (e ErrQueueNotFound) Error() string {
+	return fmt.Sprintf("queue %s does not exist: %v", e.QueueName, e.err)
+}
+
+func (e ErrQueueNotFound) Unwrap() error {
+	return e.err
+}
+
+// ErrTopicNotFound is returned when the SNS topic does not exist.
+type ErrTopicNotFound struct {
+	TopicARN string
+	err      error
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return fmt.Sprintf("topic %s does not exist: %v", e.TopicARN, e.err)
+}
+
+func (e ErrTopicNotFound) Unwrap() error {
+	return e.err
+}
+
+// ErrInvalidCredentials is returned when AWS credentials are missing or are
+// rejected.
+type ErrInvalidCredentials struct {
+	err error
+}
+
+func (e ErrInvalidCredentials) Error() string {
+	return "invalid AWS credentials: " + e.err.Error()
+}
+
+func (e ErrInvalidCredentials) Unwrap() error {
+	return e.err
+}
+
+// ErrPayloadTooLarge is returned when a message, including its attributes,
+// is larger than the queue accepts. Use an OffloadQueue to send larger
+// payloads.
+type ErrPayloadTooLarge struct {
+	Size  int
+	Limit int
+}
+
+func (e ErrPayloadTooLarge) Error() string {
+	return fmt.Sprintf("message size %d exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+var credentialsErrorCodes = map[string]bool{
+	"NoCredentialProviders":       true,
+	"InvalidClientTokenId":        true,
+	"InvalidAccessKeyId":          true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+	"MissingAuthenticationToken":  true,
+}
+
+// sqsError converts errors returned by the SQS client to the typed errors
+// above when they match.
+func sqsError(queueName string, err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+	switch {
+	case aerr.Code() == sqs.ErrCodeQueueDoesNotExist:
+		return ErrQueueNotFound{QueueName: queueName, err: err}
+	case credentialsErrorCodes[aerr.Code()] || request.IsErrorExpiredCreds(err):
+		return ErrInvalidCredentials{err: err}
+	case aerr.Code() == sqs.ErrCodeBatchRequestTooLong:
+		return ErrPayloadTooLarge{Limit: sqsMaxMessageSize}
+	}
+	return err
+}
+
+// snsError converts errors returned by the SNS client to the typed errors
+// above when they match.
+func snsError(topicARN string, err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+	switch {
+	case aerr.Code() == sns.ErrCodeNotFoundException:
+		return ErrTopicNotFound{TopicARN: topicARN, err: err}
+	case credentialsErrorCodes[aerr.Code()] || request.IsErrorExpiredCreds(err):
+		return ErrInvalidCredentials{err: err}
+	}
+	return err
+}
+
+// isRetryableAWSError reports whether an error returned by an AWS client is
+// caused by throttling or is otherwise transient.
+func isRetryableAWSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(awserr.Error); !ok {
+		// errors that did not come from a request, like a canceled context
+		return false
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrFIFODelay is returned when a message for a FIFO queue has
+	// DelaySeconds set. FIFO queues only support a queue-level delay.
+	ErrFIFODelay = errors.New("per-message DelaySeconds is not supported by FIFO queues")
+	// ErrFIFOGroupRequired is returned when a message for a FIFO queue has no
+	// MessageGroupID.
+	ErrFIFOGroupRequired = errors.New("MessageGroupID is required by FIFO queues")
+	// ErrNotFIFO is returned when a message for a standard queue has a
+	// MessageGroupID or DeduplicationID set.
+	ErrNotFIFO = errors.New("MessageGroupID and DeduplicationID are only supported by FIFO queues")
+)
+
+// IsFIFOQueue reports whether queueName is the name of a FIFO queue.
+func IsFIFOQueue(queueName string) bool {
+	return strings.HasSuffix(queueName, ".fifo")
+}
+
+// validateMessage checks that msg can be sent to queueName.
+func validateMessage(queueName string, msg *SQSMessage) error {
+	if IsFIFOQueue(queueName) {
+		if msg.DelaySeconds > 0 {
+			return fmt.Errorf("queue %s: %w", queueName, ErrFIFODelay)
+		}
+		if msg.GroupID == "" {
+			return fmt.Errorf("queue %s: %w", queueName, ErrFIFOGroupRequired)
+		}
+		return nil
+	}
+	if msg.GroupID != "" || msg.DeduplicationID != "" {
+		return fmt.Errorf("queue %s: %w", queueName, ErrNotFIFO)
+	}
+	return nil
+}
+
+// contentDeduplicationID derives a deduplication ID from the action and body
+// of a message, so that it works whether or not content-based deduplication
+// is enabled on the queue.
+func contentDeduplicationID(action, body string) string {
+	h := sha256.New()
+	h.Write([]byte(action))
+	h.Write([]byte{0})
+	h.Write([]byte(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIFOValidation(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	err := SQSDeliverMessageWithOptions("licenses.fifo", "update", nil, PublishOptions{
+		MessageGroupID: "customer-1",
+		DelaySeconds:   10,
+	})
+	assert.True(t, errors.Is(err, ErrFIFODelay), err)
+
+	err = SQSDeliverMessageWithOptions("licenses.fifo", "update", nil, PublishOptions{})
+	assert.True(t, errors.Is(err, ErrFIFOGroupRequired), err)
+
+	err = SQSDeliverMessageWithOptions("licenses", "update", nil, PublishOptions{MessageGroupID: "customer-1"})
+	assert.True(t, errors.Is(err, ErrNotFIFO), err)
+
+	assert.Empty(t, q.Sent("licenses.fifo"))
+}
+
+func TestFIFOContentBasedDeduplication(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	opts := PublishOptions{
+		MessageGroupID:            "customer-1",
+		ContentBasedDeduplication: true,
+	}
+	require.NoError(t, SQSDeliverMessageWithOptions("licenses.fifo", "update", map[string]int{"seats": 1}, opts))
+	require.NoError(t, SQSDeliverMessageWithOptions("licenses.fifo", "update", map[string]int{"seats": 1}, opts))
+	require.NoError(t, SQSDeliverMessageWithOptions("licenses.fifo", "revoke", map[string]int{"seats": 1}, opts))
+
+	sent := q.Sent("licenses.fifo")
+	require.Len(t, sent, 2)
+	assert.NotEqual(t, sent[0].DeduplicationID, sent[1].DeduplicationID)
+
+	opts = PublishOptions{MessageGroupID: "customer-1", DeduplicationID: "explicit"}
+	require.NoError(t, SQSDeliverMessageWithOptions("licenses.fifo", "update", 1, opts))
+	require.NoError(t, SQSDeliverMessageWithOptions("licenses.fifo", "update", 2, opts))
+	assert.Len(t, q.Sent("licenses.fifo"), 3)
+}
+
+func TestMemoryQueueFIFOGroupOrdering(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	for _, m := range []*SQSMessage{
+		{Action: "a1", GroupID: "a", DeduplicationID: "1"},
+		{Action: "b1", GroupID: "b", DeduplicationID: "2"},
+		{Action: "a2", GroupID: "a", DeduplicationID: "3"},
+	} {
+		require.NoError(t, q.Send(ctx, "test.fifo", m))
+	}
+
+	received, err := q.Receive(ctx, "test.fifo", ReceiveOptions{MaxMessages: 1})
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, "a1", received[0].Action)
+
+	// a2 waits until a1 is deleted
+	received, err = q.Receive(ctx, "test.fifo", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, "b1", received[0].Action)
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// sqsMaxVisibilityTimeout is the longest a message can be kept invisible,
+// counted from when it was received.
+const sqsMaxVisibilityTimeout = 12 * 60 * 60
+
+// heartbeat extends the visibility of msg in the background until the
+// returned stop function is called or MaxHandleSeconds have passed, in which
+// case the returned context is canceled. The deadline applies even when
+// heartbeats are disabled or the queue cannot change visibility. stop waits
+// for the heartbeat to end.
+func (c *SQSConsumer) heartbeat(ctx context.Context, msg *SQSMessage) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	deadline := time.NewTimer(time.Duration(c.opts.MaxHandleSeconds) * time.Second)
+	// ticks stays nil, and never fires, without heartbeats
+	var ticker *time.Ticker
+	var ticks <-chan time.Time
+	v, ok := c.opts.Queue.(VisibilityChanger)
+	if ok && !c.opts.DisableHeartbeat {
+		ticker = time.NewTicker(time.Duration(c.opts.HeartbeatTimeout) * time.Second / 2)
+		ticks = ticker.C
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer deadline.Stop()
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-deadline.C:
+				log.Printf("Handler for %q message %s from %s exceeded %ds, canceling it", msg.Action, msg.ID, c.queueName, c.opts.MaxHandleSeconds)
+				cancel()
+				return
+			case <-ticks:
+				if err := v.ChangeVisibility(ctx, c.queueName, msg.ReceiptHandle, c.opts.HeartbeatTimeout); err != nil {
+					log.Printf("Failed to extend visibility of message %s on %s: %v", msg.ID, c.queueName, err)
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		wg.Wait()
+		cancel()
+	}
+}
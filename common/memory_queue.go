@@ -0,0 +1,214 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMemoryVisibilityTimeout matches the SQS default for new queues.
+	defaultMemoryVisibilityTimeout = 30 * time.Second
+	// memoryDeduplicationInterval matches the SQS FIFO deduplication interval.
+	memoryDeduplicationInterval = 5 * time.Minute
+)
+
+// MemoryQueue is an in-process Queue for tests. It honours delays, visibility
+// timeouts and FIFO ordering and deduplication, and records every message
+// sent so tests can assert on what was enqueued.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	queues  map[string][]*memoryEntry
+	sent    map[string][]SQSMessage
+	dedup   map[string]time.Time
+	nextID  int
+	changed chan struct{}
+}
+
+type memoryEntry struct {
+	msg       SQSMessage
+	visibleAt time.Time
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		queues:  map[string][]*memoryEntry{},
+		sent:    map[string][]SQSMessage{},
+		dedup:   map[string]time.Time{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Send enqueues msg. Like SQS, a FIFO message whose deduplication ID was
+// seen in the last 5 minutes is accepted but neither enqueued nor recorded.
+func (q *MemoryQueue) Send(ctx context.Context, queueName string, msg *SQSMessage) error {
+	if err := validateMessage(queueName, msg); err != nil {
+		return err
+	}
+	if size := sqsMessageSize(msg); size > sqsMaxMessageSize {
+		return ErrPayloadTooLarge{Size: size, Limit: sqsMaxMessageSize}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if IsFIFOQueue(queueName) && msg.DeduplicationID != "" {
+		key := queueName + "/" + msg.DeduplicationID
+		if sentAt, ok := q.dedup[key]; ok && time.Since(sentAt) < memoryDeduplicationInterval {
+			return nil
+		}
+		q.dedup[key] = time.Now()
+	}
+
+	q.nextID++
+	m := SQSMessage{
+		ID:              fmt.Sprintf("memory-%d", q.nextID),
+		Action:          msg.Action,
+		Body:            msg.Body,
+		DelaySeconds:    msg.DelaySeconds,
+		GroupID:         msg.GroupID,
+		DeduplicationID: msg.DeduplicationID,
+		Attributes:      copyAttributes(msg.Attributes),
+	}
+	q.sent[queueName] = append(q.sent[queueName], m)
+	q.queues[queueName] = append(q.queues[queueName], &memoryEntry{
+		msg:       m,
+		visibleAt: time.Now().Add(time.Duration(msg.DelaySeconds) * time.Second),
+	})
+	q.notify()
+	return nil
+}
+
+func (q *MemoryQueue) Receive(ctx context.Context, queueName string, opts ReceiveOptions) ([]*SQSMessage, error) {
+	max := int(opts.MaxMessages)
+	if max <= 0 {
+		max = 1
+	}
+	visibility := defaultMemoryVisibilityTimeout
+	if opts.VisibilityTimeout > 0 {
+		visibility = time.Duration(opts.VisibilityTimeout) * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(opts.WaitTimeSeconds) * time.Second)
+
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var messages []*SQSMessage
+		next := deadline
+		// FIFO groups with a message in flight are skipped to keep order
+		blocked := map[string]bool{}
+		for _, e := range q.queues[queueName] {
+			if e.msg.GroupID != "" && blocked[e.msg.GroupID] {
+				continue
+			}
+			if e.visibleAt.After(now) {
+				if e.visibleAt.Before(next) {
+					next = e.visibleAt
+				}
+				if e.msg.GroupID != "" {
+					blocked[e.msg.GroupID] = true
+				}
+				continue
+			}
+			if len(messages) == max {
+				break
+			}
+			q.nextID++
+			e.msg.ReceiptHandle = fmt.Sprintf("%s-%d", e.msg.ID, q.nextID)
+			e.msg.ReceiveCount++
+			e.visibleAt = now.Add(visibility)
+			m := e.msg
+			m.Attributes = copyAttributes(e.msg.Attributes)
+			unwrapSNSNotification(&m)
+			messages = append(messages, &m)
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		if len(messages) > 0 || !now.Before(deadline) {
+			return messages, nil
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (q *MemoryQueue) Delete(ctx context.Context, queueName string, receiptHandle string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := q.queues[queueName]
+	for i, e := range entries {
+		if e.msg.ReceiptHandle == receiptHandle {
+			q.queues[queueName] = append(entries[:i:i], entries[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("receipt handle %q not found in queue %s", receiptHandle, queueName)
+}
+
+func (q *MemoryQueue) ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, timeoutSeconds int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, e := range q.queues[queueName] {
+		if e.msg.ReceiptHandle == receiptHandle {
+			e.visibleAt = time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+			q.notify()
+			return nil
+		}
+	}
+	return fmt.Errorf("receipt handle %q not found in queue %s", receiptHandle, queueName)
+}
+
+// Sent returns every message sent to the queue, including ones that have
+// since been received and deleted.
+func (q *MemoryQueue) Sent(queueName string) []SQSMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]SQSMessage(nil), q.sent[queueName]...)
+}
+
+// Len returns the number of messages that have not been deleted yet,
+// including delayed and in-flight ones.
+func (q *MemoryQueue) Len(queueName string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[queueName])
+}
+
+// Reset drops all messages and the record of sent messages.
+func (q *MemoryQueue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queues = map[string][]*memoryEntry{}
+	q.sent = map[string][]SQSMessage{}
+	q.dedup = map[string]time.Time{}
+	q.notify()
+}
+
+// notify wakes up receivers waiting for messages. Must be called with mu held.
+func (q *MemoryQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func copyAttributes(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+	c := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		c[name] = value
+	}
+	return c
+}
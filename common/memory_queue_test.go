@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQSDeliverMessageRecordsOnDefaultQueue(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	err := SQSDeliverMessage("test-queue", "do_thing", map[string]string{"key": "value"}, 5)
+	require.NoError(t, err)
+
+	sent := q.Sent("test-queue")
+	require.Len(t, sent, 1)
+	assert.Equal(t, "do_thing", sent[0].Action)
+	assert.Equal(t, `{"key":"value"}`, sent[0].Body)
+	assert.Equal(t, 5, sent[0].DelaySeconds)
+}
+
+func TestMemoryQueueVisibility(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"}))
+
+	opts := ReceiveOptions{MaxMessages: 10, VisibilityTimeout: 1}
+	received, err := q.Receive(ctx, "test-queue", opts)
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, 1, received[0].ReceiveCount)
+
+	// in flight
+	received, err = q.Receive(ctx, "test-queue", opts)
+	require.NoError(t, err)
+	assert.Len(t, received, 0)
+
+	// redelivered after the visibility timeout, with a new receipt handle
+	opts.WaitTimeSeconds = 2
+	redelivered, err := q.Receive(ctx, "test-queue", opts)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	assert.Equal(t, 2, redelivered[0].ReceiveCount)
+
+	require.NoError(t, q.Delete(ctx, "test-queue", redelivered[0].ReceiptHandle))
+	assert.Equal(t, 0, q.Len("test-queue"))
+	assert.Len(t, q.Sent("test-queue"), 1)
+}
+
+func TestMemoryQueueDelay(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", DelaySeconds: 1}))
+
+	received, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+	require.NoError(t, err)
+	assert.Len(t, received, 0)
+
+	start := time.Now()
+	received, err = q.Receive(ctx, "test-queue", ReceiveOptions{WaitTimeSeconds: 2})
+	require.NoError(t, err)
+	assert.Len(t, received, 1)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+func TestSQSDeliverMessageBatch(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	payloads := []interface{}{
+		map[string]string{"id": "1"},
+		func() {}, // cannot be marshalled
+		map[string]string{"id": "3"},
+	}
+	err := SQSDeliverMessageBatch("test-queue", "notify", payloads, 0)
+	require.IsType(t, &BatchError{}, err)
+	failures := err.(*BatchError).Failures
+	require.Len(t, failures, 1)
+	assert.Equal(t, 1, failures[0].Index)
+
+	sent := q.Sent("test-queue")
+	require.Len(t, sent, 2)
+	assert.Equal(t, `{"id":"3"}`, sent[1].Body)
+}
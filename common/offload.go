@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// offloadAttribute marks a message whose body was moved to a PayloadStore.
+// Its value is the reference returned by the store.
+const offloadAttribute = "OffloadedPayload"
+
+// OffloadQueue wraps a Queue to move message bodies larger than a threshold
+// to a PayloadStore, sending a pointer message in their place. Messages
+// received through an OffloadQueue have their bodies restored, and the stored
+// body is removed when the message is deleted, or right away if the message
+// could not be sent. Bodies of messages that are
+// never deleted, such as ones redriven out of a DLQ by hand, should be cleaned
+// up by a lifecycle rule on the store.
+type OffloadQueue struct {
+	queue     Queue
+	store     PayloadStore
+	threshold int
+
+	// refs maps receipt handles of restored messages to their stored body
+	refs map[string]offloadRef
+	mu   sync.Mutex
+}
+
+// offloadRef is the stored body of a received message. Entries are dropped
+// when the message is redelivered under a new receipt handle, or once the
+// handle has expired for messages that are never seen again.
+type offloadRef struct {
+	ref       string
+	messageID string
+	received  time.Time
+}
+
+// NewOffloadQueue returns an OffloadQueue that offloads messages whose size
+// exceeds threshold bytes. A threshold of 0 uses the SQS message size limit.
+func NewOffloadQueue(queue Queue, store PayloadStore, threshold int) *OffloadQueue {
+	if threshold <= 0 {
+		threshold = sqsMaxMessageSize
+	}
+	return &OffloadQueue{
+		queue:     queue,
+		store:     store,
+		threshold: threshold,
+		refs:      map[string]offloadRef{},
+	}
+}
+
+func (q *OffloadQueue) Send(ctx context.Context, queueName string, msg *SQSMessage) error {
+	msg, err := q.offload(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if err := q.queue.Send(ctx, queueName, msg); err != nil {
+		q.discard(ctx, msg)
+		return err
+	}
+	return nil
+}
+
+func (q *OffloadQueue) SendBatch(ctx context.Context, queueName string, msgs []*SQSMessage) error {
+	batchErr := &BatchError{}
+	offloaded := make([]*SQSMessage, 0, len(msgs))
+	indexes := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		m, err := q.offload(ctx, msg)
+		if err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		offloaded = append(offloaded, m)
+		indexes = append(indexes, i)
+	}
+
+	err := SendBatch(ctx, q.queue, queueName, offloaded)
+	if e, ok := err.(*BatchError); ok {
+		for _, f := range e.Failures {
+			q.discard(ctx, offloaded[f.Index])
+		}
+	} else if err != nil {
+		for _, m := range offloaded {
+			q.discard(ctx, m)
+		}
+	}
+	return remapBatchError(batchErr, err, indexes)
+}
+
+// Receive returns received messages with offloaded bodies restored. A message
+// whose body cannot be fetched is left on the queue to be redelivered.
+func (q *OffloadQueue) Receive(ctx context.Context, queueName string, opts ReceiveOptions) ([]*SQSMessage, error) {
+	received, err := q.queue.Receive(ctx, queueName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*SQSMessage, 0, len(received))
+	for _, msg := range received {
+		ref, ok := msg.Attributes[offloadAttribute]
+		if !ok {
+			messages = append(messages, msg)
+			continue
+		}
+
+		body, err := q.store.Get(ctx, ref)
+		if err != nil {
+			log.Printf("Failed to get offloaded payload %s of message %s from %s: %v", ref, msg.ID, queueName, err)
+			continue
+		}
+		msg.Body = string(body)
+		delete(msg.Attributes, offloadAttribute)
+
+		q.track(msg, ref)
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (q *OffloadQueue) Delete(ctx context.Context, queueName string, receiptHandle string) error {
+	if err := q.queue.Delete(ctx, queueName, receiptHandle); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	r, ok := q.refs[receiptHandle]
+	delete(q.refs, receiptHandle)
+	q.mu.Unlock()
+
+	if ok {
+		if err := q.store.Delete(ctx, r.ref); err != nil {
+			log.Printf("Failed to delete offloaded payload %s: %v", r.ref, err)
+		}
+	}
+	return nil
+}
+
+// track records the stored body of a received message so that it is removed
+// along with the message.
+func (q *OffloadQueue) track(msg *SQSMessage, ref string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for handle, r := range q.refs {
+		redelivered := msg.ID != "" && r.messageID == msg.ID
+		if redelivered || now.Sub(r.received) > sqsMaxVisibilityTimeout*time.Second {
+			delete(q.refs, handle)
+		}
+	}
+	q.refs[msg.ReceiptHandle] = offloadRef{ref: ref, messageID: msg.ID, received: now}
+}
+
+// ChangeVisibility forwards to the wrapped queue if it is a
+// VisibilityChanger.
+func (q *OffloadQueue) ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, timeoutSeconds int64) error {
+	return changeVisibility(ctx, q.queue, queueName, receiptHandle, timeoutSeconds)
+}
+
+// offload returns msg unchanged if it is under the threshold, or a copy
+// pointing at its body in the store otherwise.
+func (q *OffloadQueue) offload(ctx context.Context, msg *SQSMessage) (*SQSMessage, error) {
+	if sqsMessageSize(msg) <= q.threshold {
+		return msg, nil
+	}
+
+	ref, err := q.store.Put(ctx, []byte(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	m := *msg
+	m.Body = ref
+	m.Attributes = copyAttributes(msg.Attributes)
+	if m.Attributes == nil {
+		m.Attributes = map[string]string{}
+	}
+	m.Attributes[offloadAttribute] = ref
+	return &m, nil
+}
+
+// discard removes the stored body of msg, as returned by offload, after it
+// could not be sent.
+func (q *OffloadQueue) discard(ctx context.Context, msg *SQSMessage) {
+	ref, ok := msg.Attributes[offloadAttribute]
+	if !ok {
+		return
+	}
+	if err := q.store.Delete(ctx, ref); err != nil {
+		log.Printf("Failed to delete offloaded payload %s: %v", ref, err)
+	}
+}
+
+// newRandomID returns 16 random bytes, hex encoded.
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
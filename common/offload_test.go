@@ -0,0 +1,162 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffloadQueue(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mem := NewMemoryQueue()
+	q := NewOffloadQueue(mem, NewDirPayloadStore(dir), 1024)
+
+	large := `"` + strings.Repeat("x", 2048) + `"`
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "big", Body: large}))
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "small", Body: "{}"}))
+
+	sent := mem.Sent("test-queue")
+	require.Len(t, sent, 2)
+	ref := sent[0].Attributes[offloadAttribute]
+	assert.NotEmpty(t, ref)
+	assert.Equal(t, ref, sent[0].Body)
+	assert.Equal(t, "{}", sent[1].Body)
+	assert.FileExists(t, dir+"/"+ref)
+
+	received, err := q.Receive(ctx, "test-queue", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, received, 2)
+	assert.Equal(t, "big", received[0].Action)
+	assert.Equal(t, large, received[0].Body)
+	assert.NotContains(t, received[0].Attributes, offloadAttribute)
+
+	require.NoError(t, q.Delete(ctx, "test-queue", received[0].ReceiptHandle))
+	assert.NoFileExists(t, dir+"/"+ref)
+}
+
+func TestOffloadQueueForgetsRedeliveredMessages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mem := NewMemoryQueue()
+	q := NewOffloadQueue(mem, NewDirPayloadStore(dir), 1024)
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "big", Body: `"` + strings.Repeat("x", 2048) + `"`}))
+
+	consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{Queue: q, DisableHeartbeat: true})
+	fail := true
+	consumer.Handle("big", func(ctx context.Context, msg *SQSMessage) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		fail = i < 2
+		received, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+		require.NoError(t, err)
+		require.Len(t, received, 1)
+		consumer.process(ctx, received[0])
+		if fail {
+			// make the message visible again, as its timeout expiring would
+			require.NoError(t, q.ChangeVisibility(ctx, "test-queue", received[0].ReceiptHandle, 0))
+			assert.Len(t, q.refs, 1)
+		}
+	}
+
+	assert.Empty(t, q.refs)
+	assert.Equal(t, 0, mem.Len("test-queue"))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+// rejectingQueue fails to send messages with the action "reject".
+type rejectingQueue struct {
+	*MemoryQueue
+}
+
+func (q rejectingQueue) Send(ctx context.Context, queueName string, msg *SQSMessage) error {
+	if msg.Action == "reject" {
+		return errors.New("rejected")
+	}
+	return q.MemoryQueue.Send(ctx, queueName, msg)
+}
+
+// failingStore fails to store bodies starting with "fail".
+type failingStore struct {
+	*DirPayloadStore
+}
+
+func (s failingStore) Put(ctx context.Context, body []byte) (string, error) {
+	if strings.HasPrefix(string(body), "fail") {
+		return "", errors.New("failed")
+	}
+	return s.DirPayloadStore.Put(ctx, body)
+}
+
+func TestOffloadQueueSendBatchFailures(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mem := NewMemoryQueue()
+	q := NewOffloadQueue(rejectingQueue{mem}, failingStore{NewDirPayloadStore(dir)}, 1024)
+
+	err := q.SendBatch(ctx, "test-queue", []*SQSMessage{
+		{Action: "reject", Body: "{}"},
+		{Action: "big", Body: "fail" + strings.Repeat("x", 2048)},
+		{Action: "reject", Body: strings.Repeat("x", 2048)},
+		{Action: "small", Body: "{}"},
+	})
+	require.IsType(t, &BatchError{}, err)
+	failures := err.(*BatchError).Failures
+	require.Len(t, failures, 3)
+	assert.Equal(t, 0, failures[0].Index)
+	assert.Equal(t, 1, failures[1].Index)
+	assert.Equal(t, 2, failures[2].Index)
+	assert.Equal(t, 1, mem.Len("test-queue"))
+
+	// the body of the rejected large message is not left behind
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	err = q.Send(ctx, "test-queue", &SQSMessage{Action: "reject", Body: strings.Repeat("x", 2048)})
+	assert.Error(t, err)
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestDirPayloadStoreRejectsPaths(t *testing.T) {
+	s := NewDirPayloadStore(t.TempDir())
+	_, err := s.Get(context.Background(), "../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestNewS3PayloadStoreWithoutSession(t *testing.T) {
+	t.Setenv("USE_EC2_PARAMETERS", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err := NewS3PayloadStore(nil, "payloads", "queues/")
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := NewS3PayloadStore(nil, "payloads", "queues/")
+	require.NoError(t, err)
+	assert.NotNil(t, s.svc)
+}
+
+func TestParseS3Ref(t *testing.T) {
+	bucket, key, err := parseS3Ref("s3://payloads/queues/abc")
+	require.NoError(t, err)
+	assert.Equal(t, "payloads", bucket)
+	assert.Equal(t, "queues/abc", key)
+
+	_, _, err = parseS3Ref("payloads/abc")
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PayloadStore stores message bodies that are too large to be sent inline.
+type PayloadStore interface {
+	// Put stores body and returns a reference to it.
+	Put(ctx context.Context, body []byte) (string, error)
+	// Get returns the body stored under ref.
+	Get(ctx context.Context, ref string) ([]byte, error)
+	// Delete removes the body stored under ref.
+	Delete(ctx context.Context, ref string) error
+}
+
+// S3PayloadStore stores payloads as objects in an S3 bucket. References are
+// s3://bucket/key URLs. The region is read from AWS_REGION and S3_ENDPOINT can
+// point it at a local stand-in.
+type S3PayloadStore struct {
+	svc    *s3.S3
+	bucket string
+	prefix string
+}
+
+// NewS3PayloadStore returns a store writing objects under prefix in bucket.
+// A nil sess creates a session from the environment.
+func NewS3PayloadStore(sess *session.Session, bucket, prefix string) (*S3PayloadStore, error) {
+	sess, err := envSession(sess)
+	if err != nil {
+		return nil, err
+	}
+	config := awsConfig("S3_ENDPOINT")
+	if os.Getenv("S3_ENDPOINT") != "" {
+		config = config.WithS3ForcePathStyle(true)
+	}
+	return &S3PayloadStore{
+		svc:    s3.New(sess, config),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3PayloadStore) Put(ctx context.Context, body []byte) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	key := s.prefix + id
+
+	_, err = s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+func (s *S3PayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	bucket, key, err := parseS3Ref(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+func (s *S3PayloadStore) Delete(ctx context.Context, ref string) error {
+	bucket, key, err := parseS3Ref(ref)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func parseS3Ref(ref string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(ref, "s3://"), "/", 2)
+	if !strings.HasPrefix(ref, "s3://") || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid S3 payload reference %q", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// DirPayloadStore stores payloads as files in a local directory. It stands in
+// for S3PayloadStore in development and tests. References are file names.
+type DirPayloadStore struct {
+	dir string
+}
+
+func NewDirPayloadStore(dir string) *DirPayloadStore {
+	return &DirPayloadStore{dir: dir}
+}
+
+func (s *DirPayloadStore) Put(ctx context.Context, body []byte) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir, id), body, 0600); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *DirPayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (s *DirPayloadStore) Delete(ctx context.Context, ref string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *DirPayloadStore) path(ref string) (string, error) {
+	if ref == "" || ref != filepath.Base(ref) {
+		return "", fmt.Errorf("invalid payload reference %q", ref)
+	}
+	return filepath.Join(s.dir, ref), nil
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// PublishOptions are per-message options for SQSDeliverMessageWithOptions.
+type PublishOptions struct {
+	// DelaySeconds delays delivery. Delays over 900 seconds are handled like
+	// DeliverAt. Not supported by FIFO queues.
+	DelaySeconds int
+	// DeliverAt is the time before which the message is not handled. Times
+	// more than 15 minutes away require the message to be consumed by an
+	// SQSConsumer, which re-enqueues it until then. Not supported by FIFO
+	// queues.
+	DeliverAt time.Time
+
+	// MessageGroupID is the FIFO message group. Messages in the same group
+	// are delivered in order. Required for FIFO queues.
+	MessageGroupID string
+	// DeduplicationID is the FIFO deduplication ID. Messages with the same ID
+	// sent within 5 minutes are delivered once.
+	DeduplicationID string
+	// ContentBasedDeduplication derives DeduplicationID from the action and
+	// payload when DeduplicationID is empty.
+	ContentBasedDeduplication bool
+
+	// SchemaVersion is the version of the payload schema written to the
+	// message envelope. Defaults to 1.
+	SchemaVersion int
+}
+
+// SQSDeliverMessageWithOptions marshals payload to JSON and sends it to
+// queueName with the given action.
+func SQSDeliverMessageWithOptions(queueName, action string, payload interface{}, opts PublishOptions) error {
+	return SQSDeliverMessageContext(context.Background(), queueName, action, payload, opts)
+}
+
+// SQSDeliverMessageContext is SQSDeliverMessageWithOptions with a context.
+// Throttled and transient failures are retried until ctx is done, following
+// the RetryPolicy of the queue. The returned error is an ErrQueueNotFound,
+// ErrInvalidCredentials or ErrPayloadTooLarge when it is caused by one of
+// those conditions.
+func SQSDeliverMessageContext(ctx context.Context, queueName, action string, payload interface{}, opts PublishOptions) error {
+	msg, err := newMessage(ctx, action, payload, opts)
+	if err != nil {
+		return err
+	}
+	return DefaultQueue().Send(ctx, queueName, msg)
+}
+
+func newMessage(ctx context.Context, action string, payload interface{}, opts PublishOptions) (*SQSMessage, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &SQSMessage{
+		Action:          action,
+		Body:            string(b),
+		GroupID:         opts.MessageGroupID,
+		DeduplicationID: opts.DeduplicationID,
+	}
+	switch {
+	case !opts.DeliverAt.IsZero():
+		msg.schedule(opts.DeliverAt)
+	case opts.DelaySeconds > sqsMaxDelaySeconds:
+		msg.schedule(time.Now().Add(time.Duration(opts.DelaySeconds) * time.Second))
+	case opts.DelaySeconds > 0:
+		msg.DelaySeconds = opts.DelaySeconds
+	}
+	if msg.DeduplicationID == "" && opts.ContentBasedDeduplication {
+		msg.DeduplicationID = contentDeduplicationID(msg.Action, msg.Body)
+	}
+
+	env, err := newEnvelope(ctx, opts.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.setEnvelope(env); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Queue is a message queue backend. SQSQueue talks to SQS and MemoryQueue
+// keeps messages in process for tests.
+type Queue interface {
+	// Send enqueues msg. Only Action, Body, Attributes, DelaySeconds,
+	// GroupID and DeduplicationID are used.
+	Send(ctx context.Context, queueName string, msg *SQSMessage) error
+	// Receive returns up to opts.MaxMessages messages, waiting at most
+	// opts.WaitTimeSeconds for one to become available.
+	Receive(ctx context.Context, queueName string, opts ReceiveOptions) ([]*SQSMessage, error)
+	// Delete removes a received message from the queue.
+	Delete(ctx context.Context, queueName string, receiptHandle string) error
+}
+
+// VisibilityChanger is implemented by queues that can change the visibility
+// timeout of a received message.
+type VisibilityChanger interface {
+	// ChangeVisibility makes the message invisible for timeoutSeconds from
+	// now. A timeout of 0 makes it visible immediately.
+	ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, timeoutSeconds int64) error
+}
+
+// changeVisibility forwards a ChangeVisibility call to queue, for queues that
+// wrap another queue.
+func changeVisibility(ctx context.Context, queue Queue, queueName string, receiptHandle string, timeoutSeconds int64) error {
+	v, ok := queue.(VisibilityChanger)
+	if !ok {
+		return fmt.Errorf("queue %T does not support changing visibility", queue)
+	}
+	return v.ChangeVisibility(ctx, queueName, receiptHandle, timeoutSeconds)
+}
+
+type ReceiveOptions struct {
+	MaxMessages     int64
+	WaitTimeSeconds int64
+	// VisibilityTimeout overrides the queue's visibility timeout when set.
+	VisibilityTimeout int64
+}
+
+var (
+	defaultQueue   Queue
+	defaultQueueMu sync.RWMutex
+
+	envSQSQueue     *SQSQueue
+	envSQSQueueOnce sync.Once
+)
+
+// SetDefaultQueue sets the queue used by SQSDeliverMessage and consumers
+// created without a queue. Passing nil restores the SQS backend configured
+// from the environment.
+func SetDefaultQueue(q Queue) {
+	defaultQueueMu.Lock()
+	defer defaultQueueMu.Unlock()
+	defaultQueue = q
+}
+
+// DefaultQueue returns the queue set with SetDefaultQueue or InitSQS, or a
+// shared SQSQueue configured from the environment.
+func DefaultQueue() Queue {
+	defaultQueueMu.RLock()
+	defer defaultQueueMu.RUnlock()
+	if defaultQueue == nil {
+		envSQSQueueOnce.Do(func() {
+			envSQSQueue = NewSQSQueue(nil)
+		})
+		return envSQSQueue
+	}
+	return defaultQueue
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how SQSQueue retries requests that fail because of
+// throttling or transient errors.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 1 mean a single attempt.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles for each
+	// following retry, up to MaxDelay. Each delay is randomized between half
+	// and all of its value.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of new SQSQueues.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// wait sleeps before the given retry, returning early with ctx's error if it
+// is canceled.
+func (p RetryPolicy) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(p.delay(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// do calls fn until it succeeds, returns an error that is not retryable, or
+// the attempts are exhausted. If ctx is canceled while waiting to retry, the
+// returned error wraps both ctx's error and the last error of fn.
+func (p RetryPolicy) do(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	err := fn()
+	for retry := 1; retry < p.MaxAttempts && retryable(err); retry++ {
+		if waitErr := p.wait(ctx, retry); waitErr != nil {
+			return fmt.Errorf("%w, last error: %w", waitErr, err)
+		}
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// deliverAtAttribute holds the Unix time before which a message must not
+	// be handled. Consumers re-enqueue messages received earlier than that.
+	deliverAtAttribute = "DeliverAt"
+	// sqsMaxDelaySeconds is the longest delay SQS supports.
+	sqsMaxDelaySeconds = 900
+)
+
+// SQSDeliverMessageAt sends a message that is handled no earlier than at.
+// Delivery times more than 15 minutes away are reached in hops: the message
+// is sent with the maximum delay and re-enqueued by SQSConsumer until at.
+// Standard queues only.
+func SQSDeliverMessageAt(queueName, action string, payload interface{}, at time.Time) error {
+	return SQSDeliverMessageWithOptions(queueName, action, payload, PublishOptions{DeliverAt: at})
+}
+
+// schedule sets the delay of msg so that it is delivered at deliverAt,
+// adding the attribute that makes consumers re-enqueue it if that is beyond
+// the SQS limit.
+func (m *SQSMessage) schedule(deliverAt time.Time) {
+	delay := int(time.Until(deliverAt).Round(time.Second) / time.Second)
+	if delay <= sqsMaxDelaySeconds {
+		m.DelaySeconds = delay
+		if m.DelaySeconds < 0 {
+			m.DelaySeconds = 0
+		}
+		delete(m.Attributes, deliverAtAttribute)
+		return
+	}
+
+	m.DelaySeconds = sqsMaxDelaySeconds
+	if m.Attributes == nil {
+		m.Attributes = map[string]string{}
+	}
+	m.Attributes[deliverAtAttribute] = strconv.FormatInt(deliverAt.Unix(), 10)
+}
+
+// deliverAt returns the time the message is scheduled for, if any.
+func (m *SQSMessage) deliverAt() (time.Time, bool) {
+	v, ok := m.Attributes[deliverAtAttribute]
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// reschedule re-enqueues msg if it was received before the time it is
+// scheduled for and reports whether it did. The message is deleted once the
+// new one is sent; if sending fails it is left to be received again.
+func (c *SQSConsumer) reschedule(ctx context.Context, msg *SQSMessage) bool {
+	at, ok := msg.deliverAt()
+	// delays are in whole seconds, so a message may arrive slightly early
+	if !ok || time.Until(at) < time.Second {
+		return false
+	}
+
+	next := &SQSMessage{
+		Action:     msg.Action,
+		Body:       msg.Body,
+		Attributes: copyAttributes(msg.Attributes),
+	}
+	next.schedule(at)
+	if err := c.opts.Queue.Send(ctx, c.queueName, next); err != nil {
+		log.Printf("Failed to reschedule message %s on %s: %v", msg.ID, c.queueName, err)
+		return true
+	}
+	if err := c.opts.Queue.Delete(ctx, c.queueName, msg.ReceiptHandle); err != nil {
+		log.Printf("Failed to delete rescheduled message %s from %s: %v", msg.ID, c.queueName, err)
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQSDeliverMessageAt(t *testing.T) {
+	q := NewMemoryQueue()
+	SetDefaultQueue(q)
+	defer SetDefaultQueue(nil)
+
+	at := time.Now().Add(72 * time.Hour)
+	require.NoError(t, SQSDeliverMessageAt("test-queue", "trial_expiring", 1, at))
+	require.NoError(t, SQSDeliverMessageAt("test-queue", "soon", 2, time.Now().Add(10*time.Minute)))
+	require.NoError(t, SQSDeliverMessage("test-queue", "legacy", 3, 3600))
+
+	sent := q.Sent("test-queue")
+	require.Len(t, sent, 3)
+	assert.Equal(t, sqsMaxDelaySeconds, sent[0].DelaySeconds)
+	assert.Equal(t, strconv.FormatInt(at.Unix(), 10), sent[0].Attributes[deliverAtAttribute])
+	assert.InDelta(t, 600, sent[1].DelaySeconds, 1)
+	assert.NotContains(t, sent[1].Attributes, deliverAtAttribute)
+	assert.Equal(t, sqsMaxDelaySeconds, sent[2].DelaySeconds)
+	assert.Contains(t, sent[2].Attributes, deliverAtAttribute)
+
+	err := SQSDeliverMessageAt("test.fifo", "a", 1, at)
+	assert.Error(t, err)
+}
+
+func TestSQSConsumerReschedules(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+
+	future := time.Now().Add(20 * time.Minute)
+	due := time.Now().Add(-time.Minute)
+	for action, at := range map[string]time.Time{"future": future, "due": due} {
+		require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{
+			Action:     action,
+			Body:       "{}",
+			Attributes: map[string]string{deliverAtAttribute: strconv.FormatInt(at.Unix(), 10)},
+		}))
+	}
+
+	consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{Queue: q})
+	handled := map[string]bool{}
+	handler := func(ctx context.Context, msg *SQSMessage) error {
+		handled[msg.Action] = true
+		return nil
+	}
+	consumer.Handle("future", handler)
+	consumer.Handle("due", handler)
+
+	received, err := q.Receive(ctx, "test-queue", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, received, 2)
+	for _, msg := range received {
+		consumer.process(ctx, msg)
+	}
+
+	assert.Equal(t, map[string]bool{"due": true}, handled)
+
+	// the future message was replaced by a new one, delayed by the remaining time
+	assert.Equal(t, 1, q.Len("test-queue"))
+	sent := q.Sent("test-queue")
+	require.Len(t, sent, 3)
+	assert.Equal(t, "future", sent[2].Action)
+	assert.Equal(t, sqsMaxDelaySeconds, sent[2].DelaySeconds)
+	assert.Equal(t, strconv.FormatInt(future.Unix(), 10), sent[2].Attributes[deliverAtAttribute])
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// ErrSNSDelay is returned when a message published to SNS has a delay. SNS
+// delivers immediately; set the delay on the subscribed queues instead.
+var ErrSNSDelay = errors.New("delays are not supported when publishing to SNS")
+
+// SNSPublisher publishes messages to SNS topics with the same action
+// attribute and envelope as SQSDeliverMessage, so that queues subscribed to a
+// topic can be consumed by an SQSConsumer. Like SQSQueue it is meant to be
+// long-lived. The region is read from AWS_REGION and SNS_ENDPOINT can point
+// it at a local stand-in.
+type SNSPublisher struct {
+	// Retry is applied to every request. The retries of the AWS client
+	// itself are disabled.
+	Retry RetryPolicy
+
+	sess *session.Session
+	svc  *sns.SNS
+	sync.Mutex
+}
+
+// NewSNSPublisher returns an SNSPublisher using sess. If sess is nil, a
+// session is created from the environment on first use.
+func NewSNSPublisher(sess *session.Session) *SNSPublisher {
+	return &SNSPublisher{
+		Retry: DefaultRetryPolicy,
+		sess:  sess,
+	}
+}
+
+// Publish marshals payload to JSON and publishes it to the topic. FIFO topics
+// take the MessageGroupID and deduplication options; delays are rejected with
+// ErrSNSDelay.
+func (p *SNSPublisher) Publish(ctx context.Context, topicARN, action string, payload interface{}, opts PublishOptions) error {
+	if opts.DelaySeconds > 0 || !opts.DeliverAt.IsZero() {
+		return ErrSNSDelay
+	}
+
+	msg, err := newMessage(ctx, action, payload, opts)
+	if err != nil {
+		return err
+	}
+	if err := validateMessage(topicARN, msg); err != nil {
+		return err
+	}
+	if size := sqsMessageSize(msg); size > sqsMaxMessageSize {
+		return ErrPayloadTooLarge{Size: size, Limit: sqsMaxMessageSize}
+	}
+
+	svc, err := p.client()
+	if err != nil {
+		return ErrInvalidCredentials{err: err}
+	}
+
+	input := &sns.PublishInput{
+		TopicArn:          aws.String(topicARN),
+		Message:           aws.String(msg.Body),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{},
+	}
+	for name, attr := range sqsMessageAttributes(msg) {
+		input.MessageAttributes[name] = &sns.MessageAttributeValue{
+			DataType:    attr.DataType,
+			StringValue: attr.StringValue,
+		}
+	}
+	if msg.GroupID != "" {
+		input.MessageGroupId = aws.String(msg.GroupID)
+	}
+	if msg.DeduplicationID != "" {
+		input.MessageDeduplicationId = aws.String(msg.DeduplicationID)
+	}
+
+	err = p.Retry.do(ctx, isRetryableAWSError, func() error {
+		_, err := svc.PublishWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return snsError(topicARN, err)
+	}
+	return nil
+}
+
+func (p *SNSPublisher) client() (*sns.SNS, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.svc != nil {
+		return p.svc, nil
+	}
+	sess, err := envSession(p.sess)
+	if err != nil {
+		return nil, err
+	}
+	p.svc = sns.New(sess, awsConfig("SNS_ENDPOINT").WithMaxRetries(0))
+	return p.svc, nil
+}
+
+// snsNotification is the body of an SQS message delivered from an SNS topic
+// without raw message delivery.
+type snsNotification struct {
+	Type              string `json:"Type"`
+	MessageID         string `json:"MessageId"`
+	TopicArn          string `json:"TopicArn"`
+	Message           string `json:"Message"`
+	MessageAttributes map[string]struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+}
+
+// unwrapSNSNotification replaces the body and attributes of a message
+// delivered from an SNS topic with those of the published message. Messages
+// with an action attribute, including ones delivered from SNS with raw
+// message delivery, are left untouched.
+func unwrapSNSNotification(msg *SQSMessage) {
+	if msg.Action != "" {
+		return
+	}
+
+	var n snsNotification
+	if err := json.Unmarshal([]byte(msg.Body), &n); err != nil {
+		return
+	}
+	if n.Type != "Notification" || n.TopicArn == "" {
+		return
+	}
+
+	msg.Body = n.Message
+	for name, attr := range n.MessageAttributes {
+		if attr.Type != "String" {
+			continue
+		}
+		if name == sqsActionAttribute {
+			msg.Action = attr.Value
+			continue
+		}
+		if msg.Attributes == nil {
+			msg.Attributes = map[string]string{}
+		}
+		msg.Attributes[name] = attr.Value
+	}
+}
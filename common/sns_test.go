@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNSPublisherPublish(t *testing.T) {
+	f := newFakeSQS(t)
+	var form map[string][]string
+	f.handle("Publish", func(w http.ResponseWriter, r *http.Request) {
+		form = r.PostForm
+		fmt.Fprint(w, `<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`)
+	})
+
+	p := NewSNSPublisher(f.session())
+	topic := "arn:aws:sns:us-east-1:123:events"
+	require.NoError(t, p.Publish(context.Background(), topic, "user_created", map[string]int{"id": 1}, PublishOptions{}))
+
+	assert.Equal(t, topic, form["TopicArn"][0])
+	assert.Equal(t, `{"id":1}`, form["Message"][0])
+	attrs := map[string]string{}
+	for i := 1; ; i++ {
+		name, ok := form[fmt.Sprintf("MessageAttributes.entry.%d.Name", i)]
+		if !ok {
+			break
+		}
+		attrs[name[0]] = form[fmt.Sprintf("MessageAttributes.entry.%d.Value.StringValue", i)][0]
+	}
+	assert.Equal(t, "user_created", attrs[sqsActionAttribute])
+	assert.Contains(t, attrs, envelopeAttribute)
+}
+
+func TestSNSPublisherErrors(t *testing.T) {
+	f := newFakeSQS(t)
+	f.handle("Publish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>NotFound</Code><Message>Topic does not exist</Message></Error></ErrorResponse>`)
+	})
+
+	p := NewSNSPublisher(f.session())
+	ctx := context.Background()
+	topic := "arn:aws:sns:us-east-1:123:missing"
+
+	err := p.Publish(ctx, topic, "a", 1, PublishOptions{})
+	var notFound ErrTopicNotFound
+	require.True(t, errors.As(err, &notFound), "%v", err)
+	assert.Equal(t, topic, notFound.TopicARN)
+	assert.Equal(t, 1, f.count("Publish"))
+
+	assert.Equal(t, ErrSNSDelay, p.Publish(ctx, topic, "a", 1, PublishOptions{DelaySeconds: 10}))
+	assert.ErrorIs(t, p.Publish(ctx, topic+".fifo", "a", 1, PublishOptions{}), ErrFIFOGroupRequired)
+}
+
+func TestReceiveUnwrapsSNSNotification(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	notification, err := json.Marshal(map[string]interface{}{
+		"Type":     "Notification",
+		"TopicArn": "arn:aws:sns:us-east-1:123:events",
+		"Message":  `{"id":1}`,
+		"MessageAttributes": map[string]interface{}{
+			sqsActionAttribute: map[string]string{"Type": "String", "Value": "user_created"},
+			envelopeAttribute:  map[string]string{"Type": "String", "Value": `{"id":"m1"}`},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Body: string(notification)}))
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "raw", Body: string(notification)}))
+
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{MaxMessages: 10})
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	assert.Equal(t, "user_created", msgs[0].Action)
+	assert.Equal(t, `{"id":1}`, msgs[0].Body)
+	require.NotNil(t, msgs[0].Envelope())
+	assert.Equal(t, "m1", msgs[0].Envelope().MessageID)
+
+	assert.Equal(t, "raw", msgs[1].Action)
+	assert.Equal(t, string(notification), msgs[1].Body)
+}
@@ -0,0 +1,237 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/saaskit/tracing/datadog"
+)
+
+// SQSMessage is a message received from a queue. Body is the raw JSON payload
+// written by SQSDeliverMessage and Action is the value of its "Key" attribute.
+type SQSMessage struct {
+	ID            string
+	Action        string
+	Body          string
+	ReceiptHandle string
+	ReceiveCount  int
+
+	// DelaySeconds delays delivery of a message being sent.
+	DelaySeconds int
+	// GroupID and DeduplicationID are the FIFO message group and
+	// deduplication IDs.
+	GroupID         string
+	DeduplicationID string
+
+	// Attributes are string message attributes other than the action.
+	Attributes map[string]string
+}
+
+// Unmarshal decodes the JSON payload of the message into v.
+func (m *SQSMessage) Unmarshal(v interface{}) error {
+	return json.Unmarshal([]byte(m.Body), v)
+}
+
+// SQSHandlerFunc handles a single message. A message is deleted from the
+// queue when its handler returns nil, otherwise it is left on the queue and
+// will be redelivered once its visibility timeout expires.
+type SQSHandlerFunc func(ctx context.Context, msg *SQSMessage) error
+
+// SQSJSONHandler adapts a function taking a typed payload to an
+// SQSHandlerFunc. The message body is unmarshalled into a new T before fn is
+// called; a body that cannot be decoded is treated as a handler failure.
+func SQSJSONHandler[T any](fn func(ctx context.Context, payload T) error) SQSHandlerFunc {
+	return func(ctx context.Context, msg *SQSMessage) error {
+		var payload T
+		if err := msg.Unmarshal(&payload); err != nil {
+			return fmt.Errorf("unmarshal %s payload: %w", msg.Action, err)
+		}
+		return fn(ctx, payload)
+	}
+}
+
+type SQSConsumerOptions struct {
+	// Queue is the backend messages are received from. Defaults to
+	// DefaultQueue().
+	Queue Queue
+	// Concurrency is the number of messages handled in parallel. Defaults to 1.
+	Concurrency int
+	// WaitTimeSeconds is the long polling wait time, at most 20. Defaults to 20.
+	WaitTimeSeconds int64
+	// MaxMessages is the number of messages requested per receive, at most 10.
+	// Defaults to 10.
+	MaxMessages int64
+	// VisibilityTimeout overrides the queue's visibility timeout for received
+	// messages when set.
+	VisibilityTimeout int64
+
+	// HeartbeatTimeout is the visibility timeout, in seconds, that messages
+	// are extended to while their handler runs. Visibility is extended every
+	// half of it. Defaults to VisibilityTimeout, or 30 if that is not set.
+	// Heartbeats are only sent if the Queue is a VisibilityChanger.
+	HeartbeatTimeout int64
+	// MaxHandleSeconds limits how long a handler is allowed to run. Once it is
+	// exceeded, visibility is no longer extended so that the message is
+	// redelivered, and the context of the handler is canceled. Defaults to an
+	// hour and is at most 12 hours, the SQS limit.
+	MaxHandleSeconds int64
+	// DisableHeartbeat leaves the visibility timeout of messages unchanged
+	// while they are handled. MaxHandleSeconds still applies.
+	DisableHeartbeat bool
+}
+
+// SQSConsumer long-polls a queue and dispatches messages to the handler
+// registered for their action.
+type SQSConsumer struct {
+	queueName string
+	opts      SQSConsumerOptions
+
+	handlers map[string]SQSHandlerFunc
+	mu       sync.RWMutex
+}
+
+func NewSQSConsumer(queueName string, opts SQSConsumerOptions) *SQSConsumer {
+	if opts.Queue == nil {
+		opts.Queue = DefaultQueue()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.WaitTimeSeconds <= 0 || opts.WaitTimeSeconds > 20 {
+		opts.WaitTimeSeconds = 20
+	}
+	if opts.MaxMessages <= 0 || opts.MaxMessages > 10 {
+		opts.MaxMessages = 10
+	}
+	if opts.HeartbeatTimeout <= 0 {
+		opts.HeartbeatTimeout = opts.VisibilityTimeout
+	}
+	if opts.HeartbeatTimeout <= 0 {
+		opts.HeartbeatTimeout = 30
+	}
+	if opts.MaxHandleSeconds <= 0 {
+		opts.MaxHandleSeconds = 3600
+	}
+	if opts.MaxHandleSeconds > sqsMaxVisibilityTimeout {
+		opts.MaxHandleSeconds = sqsMaxVisibilityTimeout
+	}
+	return &SQSConsumer{
+		queueName: queueName,
+		opts:      opts,
+		handlers:  map[string]SQSHandlerFunc{},
+	}
+}
+
+// Handle registers the handler for an action, replacing any previous one.
+func (c *SQSConsumer) Handle(action string, handler SQSHandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[action] = handler
+}
+
+func (c *SQSConsumer) handler(action string) (SQSHandlerFunc, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.handlers[action]
+	return h, ok
+}
+
+// Run polls the queue until ctx is canceled. On cancellation it stops
+// receiving, waits for in-flight handlers to return and returns nil. Handlers
+// are not canceled along with ctx so that they get a chance to finish, only
+// once they exceed MaxHandleSeconds. Run returns early with an
+// ErrQueueNotFound or ErrInvalidCredentials error if the queue cannot be
+// received from; other receive errors are retried.
+func (c *SQSConsumer) Run(ctx context.Context) error {
+	messages := make(chan *SQSMessage)
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range messages {
+				c.process(detachedContext{ctx}, msg)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(messages)
+
+	receiveOpts := ReceiveOptions{
+		MaxMessages:       c.opts.MaxMessages,
+		WaitTimeSeconds:   c.opts.WaitTimeSeconds,
+		VisibilityTimeout: c.opts.VisibilityTimeout,
+	}
+	for {
+		received, err := c.opts.Queue.Receive(ctx, c.queueName, receiveOpts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.As(err, &ErrQueueNotFound{}) || errors.As(err, &ErrInvalidCredentials{}) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed to receive messages from %s: %v", c.queueName, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, msg := range received {
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				// undelivered messages become visible again after their timeout
+				return nil
+			}
+		}
+	}
+}
+
+func (c *SQSConsumer) process(ctx context.Context, msg *SQSMessage) {
+	if c.reschedule(ctx, msg) {
+		return
+	}
+
+	h, ok := c.handler(msg.Action)
+	if !ok {
+		log.Printf("No handler for action %q on queue %s, message %s", msg.Action, c.queueName, msg.ID)
+		return
+	}
+
+	var trace map[string]string
+	if env := msg.Envelope(); env != nil {
+		trace = env.Trace
+	}
+	span, ctx := datadog.StartSpanFromTextMap(ctx, "queue.handle", msg.Action, trace)
+	handlerCtx, stop := c.heartbeat(ctx, msg)
+	err := h(handlerCtx, msg)
+	stop()
+	span.FinishWithError(err)
+	if err != nil {
+		log.Printf("Failed to handle %q message %s from %s: %v", msg.Action, msg.ID, c.queueName, err)
+		return
+	}
+
+	if err := c.opts.Queue.Delete(ctx, c.queueName, msg.ReceiptHandle); err != nil {
+		log.Printf("Failed to delete message %s from %s: %v", msg.ID, c.queueName, err)
+	}
+}
+
+// detachedContext keeps the values of its parent but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,179 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQSConsumerDispatch(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	q := NewMemoryQueue()
+	ctx := context.Background()
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "greet", Body: `{"name":"world"}`}))
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "fail", Body: `{}`}))
+
+	consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{
+		Queue:             q,
+		Concurrency:       2,
+		WaitTimeSeconds:   1,
+		VisibilityTimeout: 60,
+	})
+
+	greeted := make(chan string, 1)
+	consumer.Handle("greet", SQSJSONHandler(func(ctx context.Context, p payload) error {
+		greeted <- p.Name
+		return nil
+	}))
+	failed := make(chan struct{}, 1)
+	consumer.Handle("fail", func(ctx context.Context, msg *SQSMessage) error {
+		failed <- struct{}{}
+		return errors.New("failed")
+	})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(runCtx)
+	}()
+
+	select {
+	case name := <-greeted:
+		assert.Equal(t, "world", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("greet handler was not called")
+	}
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fail handler was not called")
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// the failed message is left on the queue for redelivery
+	assert.Equal(t, 1, q.Len("test-queue"))
+}
+
+func TestSQSConsumerHeartbeat(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+	require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "slow", Body: `{}`}))
+
+	consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{
+		Queue:             q,
+		VisibilityTimeout: 2,
+	})
+	consumer.Handle("slow", func(ctx context.Context, msg *SQSMessage) error {
+		time.Sleep(3 * time.Second)
+		// the message was not redelivered while the handler ran
+		redelivered, err := q.Receive(ctx, "test-queue", ReceiveOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, redelivered)
+		return nil
+	})
+
+	msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{VisibilityTimeout: 2})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	consumer.process(ctx, msgs[0])
+	assert.Equal(t, 0, q.Len("test-queue"))
+}
+
+func TestSQSConsumerMaxHandleSeconds(t *testing.T) {
+	for _, disableHeartbeat := range []bool{false, true} {
+		q := NewMemoryQueue()
+		ctx := context.Background()
+		require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "stuck", Body: `{}`}))
+
+		consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{
+			Queue:             q,
+			VisibilityTimeout: 2,
+			MaxHandleSeconds:  1,
+			DisableHeartbeat:  disableHeartbeat,
+		})
+		consumer.Handle("stuck", func(ctx context.Context, msg *SQSMessage) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				t.Errorf("handler context was not canceled, heartbeat disabled: %v", disableHeartbeat)
+				return nil
+			}
+		})
+
+		msgs, err := q.Receive(ctx, "test-queue", ReceiveOptions{VisibilityTimeout: 2})
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		consumer.process(ctx, msgs[0])
+		assert.Equal(t, 1, q.Len("test-queue"))
+	}
+}
+
+func TestSQSConsumerSQSQueue(t *testing.T) {
+	f := newFakeSQS(t)
+	var received sync.Once
+	f.handle("ReceiveMessage", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ReceiveMessageResponse><ReceiveMessageResult>`)
+		received.Do(func() {
+			for _, action := range []string{"greet", "fail", "unknown"} {
+				fmt.Fprintf(w, `<Message><MessageId>%s</MessageId><ReceiptHandle>rh-%s</ReceiptHandle><Body>{}</Body>`+
+					`<MessageAttribute><Name>Key</Name><Value><DataType>String</DataType><StringValue>%s</StringValue></Value></MessageAttribute>`+
+					`</Message>`, action, action, action)
+			}
+		})
+		fmt.Fprint(w, `</ReceiveMessageResult></ReceiveMessageResponse>`)
+	})
+	deleted := make(chan string, 3)
+	f.handle("DeleteMessage", func(w http.ResponseWriter, r *http.Request) {
+		deleted <- r.FormValue("ReceiptHandle")
+		fmt.Fprint(w, `<DeleteMessageResponse></DeleteMessageResponse>`)
+	})
+
+	consumer := NewSQSConsumer("test-queue", SQSConsumerOptions{Queue: NewSQSQueue(f.session())})
+	handled := make(chan string, 2)
+	consumer.Handle("greet", func(ctx context.Context, msg *SQSMessage) error {
+		handled <- msg.Action
+		return nil
+	})
+	consumer.Handle("fail", func(ctx context.Context, msg *SQSMessage) error {
+		handled <- msg.Action
+		return errors.New("failed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(ctx)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers were not called")
+		}
+	}
+	select {
+	case handle := <-deleted:
+		assert.Equal(t, "rh-greet", handle)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handled message was not deleted")
+	}
+	cancel()
+	require.NoError(t, <-done)
+
+	// failed and unhandled messages are left for redelivery
+	assert.Empty(t, deleted)
+	assert.Equal(t, 1, f.count("DeleteMessage"))
+}
@@ -0,0 +1,435 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	sqsMaxMessageSize  = 256 * 1024
+	sqsMaxBatchEntries = 10
+)
+
+// SQSQueue is the Queue backed by SQS. It is meant to be long-lived: the SQS
+// client is created once and queue URLs are cached by name. Region and
+// endpoint are read from the environment, so SQS_ENDPOINT can point it at a
+// local stand-in.
+type SQSQueue struct {
+	// Retry is applied to every request. The retries of the AWS client
+	// itself are disabled.
+	Retry RetryPolicy
+
+	sess *session.Session
+	svc  *sqs.SQS
+	urls map[string]string
+	sync.RWMutex
+}
+
+// NewSQSQueue returns an SQSQueue using sess. If sess is nil, a session is
+// created from the environment on first use and AWS credentials must be set
+// unless USE_EC2_PARAMETERS is.
+func NewSQSQueue(sess *session.Session) *SQSQueue {
+	return &SQSQueue{
+		Retry: DefaultRetryPolicy,
+		sess:  sess,
+		urls:  map[string]string{},
+	}
+}
+
+func (q *SQSQueue) Send(ctx context.Context, queueName string, msg *SQSMessage) error {
+	if err := validateMessage(queueName, msg); err != nil {
+		return err
+	}
+	if size := sqsMessageSize(msg); size > sqsMaxMessageSize {
+		return ErrPayloadTooLarge{Size: size, Limit: sqsMaxMessageSize}
+	}
+
+	svc, queueURL, err := q.resolve(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	sendMessageInput := &sqs.SendMessageInput{
+		MessageBody:       aws.String(msg.Body),
+		QueueUrl:          queueURL,
+		MessageAttributes: sqsMessageAttributes(msg),
+	}
+
+	if msg.DelaySeconds > 0 {
+		sendMessageInput.DelaySeconds = aws.Int64(int64(msg.DelaySeconds))
+	}
+	if msg.GroupID != "" {
+		sendMessageInput.MessageGroupId = aws.String(msg.GroupID)
+	}
+	if msg.DeduplicationID != "" {
+		sendMessageInput.MessageDeduplicationId = aws.String(msg.DeduplicationID)
+	}
+
+	return q.call(ctx, queueName, func() error {
+		_, err := svc.SendMessageWithContext(ctx, sendMessageInput)
+		return err
+	})
+}
+
+// SendBatch sends msgs with SendMessageBatch, grouped in requests of at most
+// 10 entries and 256KB. Entries that fail on the SQS side are retried with
+// the queue's RetryPolicy; entries rejected as the sender's fault are not.
+func (q *SQSQueue) SendBatch(ctx context.Context, queueName string, msgs []*SQSMessage) error {
+	svc, queueURL, err := q.resolve(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	batchErr := &BatchError{}
+	pending := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		if err := validateMessage(queueName, msg); err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		if size := sqsMessageSize(msg); size > sqsMaxMessageSize {
+			err := ErrPayloadTooLarge{Size: size, Limit: sqsMaxMessageSize}
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			if err := q.Retry.wait(ctx, attempt-1); err != nil {
+				for _, i := range pending {
+					batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+				}
+				break
+			}
+		}
+
+		var retry []int
+		for _, chunk := range sqsBatchChunks(msgs, pending) {
+			failed, err := q.sendBatchChunk(ctx, queueName, svc, queueURL, msgs, chunk)
+			if err != nil {
+				for _, i := range chunk {
+					batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+				}
+				continue
+			}
+			for i, entryErr := range failed {
+				if entryErr.retryable && attempt < q.Retry.MaxAttempts {
+					retry = append(retry, i)
+					continue
+				}
+				batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: entryErr})
+			}
+		}
+		sort.Ints(retry)
+		pending = retry
+	}
+
+	if len(batchErr.Failures) > 0 {
+		sort.Slice(batchErr.Failures, func(i, j int) bool {
+			return batchErr.Failures[i].Index < batchErr.Failures[j].Index
+		})
+		return batchErr
+	}
+	return nil
+}
+
+// sendBatchChunk sends the messages at the given indexes in one request and
+// returns the entries that failed by index.
+func (q *SQSQueue) sendBatchChunk(ctx context.Context, queueName string, svc *sqs.SQS, queueURL *string, msgs []*SQSMessage, indexes []int) (map[int]*sqsBatchEntryError, error) {
+	input := &sqs.SendMessageBatchInput{
+		QueueUrl: queueURL,
+	}
+	for _, i := range indexes {
+		entry := &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			MessageBody:       aws.String(msgs[i].Body),
+			MessageAttributes: sqsMessageAttributes(msgs[i]),
+		}
+		if msgs[i].DelaySeconds > 0 {
+			entry.DelaySeconds = aws.Int64(int64(msgs[i].DelaySeconds))
+		}
+		if msgs[i].GroupID != "" {
+			entry.MessageGroupId = aws.String(msgs[i].GroupID)
+		}
+		if msgs[i].DeduplicationID != "" {
+			entry.MessageDeduplicationId = aws.String(msgs[i].DeduplicationID)
+		}
+		input.Entries = append(input.Entries, entry)
+	}
+
+	var output *sqs.SendMessageBatchOutput
+	err := q.call(ctx, queueName, func() error {
+		var err error
+		output, err = svc.SendMessageBatchWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := map[int]*sqsBatchEntryError{}
+	for _, entry := range output.Failed {
+		i, err := strconv.Atoi(aws.StringValue(entry.Id))
+		if err != nil {
+			continue
+		}
+		failed[i] = &sqsBatchEntryError{
+			code:      aws.StringValue(entry.Code),
+			message:   aws.StringValue(entry.Message),
+			retryable: !aws.BoolValue(entry.SenderFault),
+		}
+	}
+	return failed, nil
+}
+
+type sqsBatchEntryError struct {
+	code      string
+	message   string
+	retryable bool
+}
+
+func (e *sqsBatchEntryError) Error() string {
+	return e.code + ": " + e.message
+}
+
+// sqsBatchChunks groups the messages at the given indexes into chunks that
+// fit in a single SendMessageBatch request.
+func sqsBatchChunks(msgs []*SQSMessage, indexes []int) [][]int {
+	var chunks [][]int
+	var chunk []int
+	chunkSize := 0
+	for _, i := range indexes {
+		size := sqsMessageSize(msgs[i])
+		if len(chunk) == sqsMaxBatchEntries || chunkSize+size > sqsMaxMessageSize {
+			chunks = append(chunks, chunk)
+			chunk, chunkSize = nil, 0
+		}
+		chunk = append(chunk, i)
+		chunkSize += size
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func (q *SQSQueue) Receive(ctx context.Context, queueName string, opts ReceiveOptions) ([]*SQSMessage, error) {
+	svc, queueURL, err := q.resolve(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl:              queueURL,
+		WaitTimeSeconds:       aws.Int64(opts.WaitTimeSeconds),
+		MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount,
+			sqs.MessageSystemAttributeNameMessageGroupId,
+		}),
+	}
+	if opts.MaxMessages > 0 {
+		input.MaxNumberOfMessages = aws.Int64(opts.MaxMessages)
+	}
+	if opts.VisibilityTimeout > 0 {
+		input.VisibilityTimeout = aws.Int64(opts.VisibilityTimeout)
+	}
+
+	var output *sqs.ReceiveMessageOutput
+	err = q.call(ctx, queueName, func() error {
+		var err error
+		output, err = svc.ReceiveMessageWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*SQSMessage, 0, len(output.Messages))
+	for _, m := range output.Messages {
+		messages = append(messages, messageFromSQS(m))
+	}
+	return messages, nil
+}
+
+func (q *SQSQueue) Delete(ctx context.Context, queueName string, receiptHandle string) error {
+	svc, queueURL, err := q.resolve(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	return q.call(ctx, queueName, func() error {
+		_, err := svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      queueURL,
+			ReceiptHandle: aws.String(receiptHandle),
+		})
+		return err
+	})
+}
+
+func (q *SQSQueue) ChangeVisibility(ctx context.Context, queueName string, receiptHandle string, timeoutSeconds int64) error {
+	svc, queueURL, err := q.resolve(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	return q.call(ctx, queueName, func() error {
+		_, err := svc.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          queueURL,
+			ReceiptHandle:     aws.String(receiptHandle),
+			VisibilityTimeout: aws.Int64(timeoutSeconds),
+		})
+		return err
+	})
+}
+
+func (q *SQSQueue) resolve(ctx context.Context, queueName string) (*sqs.SQS, *string, error) {
+	svc, err := q.client()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if queueURL, ok := q.urlGet(queueName); ok {
+		return svc, aws.String(queueURL), nil
+	}
+
+	getQueueURLRequest := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queueName),
+	}
+	var getQueueURLOutput *sqs.GetQueueUrlOutput
+	err = q.call(ctx, queueName, func() error {
+		var err error
+		getQueueURLOutput, err = svc.GetQueueUrlWithContext(ctx, getQueueURLRequest)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	q.urlSet(queueName, aws.StringValue(getQueueURLOutput.QueueUrl))
+	return svc, getQueueURLOutput.QueueUrl, nil
+}
+
+func (q *SQSQueue) client() (*sqs.SQS, error) {
+	q.RLock()
+	svc := q.svc
+	q.RUnlock()
+	if svc != nil {
+		return svc, nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	if q.svc != nil {
+		return q.svc, nil
+	}
+	// errors are not cached so that a missing environment can be fixed
+	svc, err := newSQSClient(q.sess)
+	if err != nil {
+		return nil, ErrInvalidCredentials{err: err}
+	}
+	q.svc = svc
+	return svc, nil
+}
+
+func (q *SQSQueue) urlGet(queueName string) (string, bool) {
+	q.RLock()
+	defer q.RUnlock()
+	queueURL, ok := q.urls[queueName]
+	return queueURL, ok
+}
+
+func (q *SQSQueue) urlSet(queueName string, queueURL string) {
+	q.Lock()
+	defer q.Unlock()
+	if q.urls == nil {
+		q.urls = map[string]string{}
+	}
+	q.urls[queueName] = queueURL
+}
+
+// call runs fn with the queue's retry policy and converts the error it
+// finally returns.
+func (q *SQSQueue) call(ctx context.Context, queueName string, fn func() error) error {
+	err := q.Retry.do(ctx, isRetryableAWSError, fn)
+	if err != nil {
+		q.invalidate(queueName, err)
+		return sqsError(queueName, err)
+	}
+	return nil
+}
+
+// invalidate drops the cached URL of a queue that no longer exists, so that
+// the next call looks it up again.
+func (q *SQSQueue) invalidate(queueName string, err error) {
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	delete(q.urls, queueName)
+}
+
+func sqsMessageAttributes(msg *SQSMessage) map[string]*sqs.MessageAttributeValue {
+	attrs := map[string]*sqs.MessageAttributeValue{
+		sqsActionAttribute: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(msg.Action),
+		},
+	}
+	for name, value := range msg.Attributes {
+		attrs[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return attrs
+}
+
+// sqsMessageSize is the size SQS counts against its limits: the body plus
+// the name, type and value of every message attribute.
+func sqsMessageSize(msg *SQSMessage) int {
+	size := len(msg.Body)
+	for name, attr := range sqsMessageAttributes(msg) {
+		size += len(name) + len(aws.StringValue(attr.DataType)) + len(aws.StringValue(attr.StringValue))
+	}
+	return size
+}
+
+func messageFromSQS(m *sqs.Message) *SQSMessage {
+	msg := &SQSMessage{
+		ID:            aws.StringValue(m.MessageId),
+		Body:          aws.StringValue(m.Body),
+		ReceiptHandle: aws.StringValue(m.ReceiptHandle),
+	}
+	for name, attr := range m.MessageAttributes {
+		if attr.StringValue == nil {
+			continue
+		}
+		if name == sqsActionAttribute {
+			msg.Action = aws.StringValue(attr.StringValue)
+			continue
+		}
+		if msg.Attributes == nil {
+			msg.Attributes = map[string]string{}
+		}
+		msg.Attributes[name] = aws.StringValue(attr.StringValue)
+	}
+	if count, ok := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; ok {
+		fmt.Sscan(aws.StringValue(count), &msg.ReceiveCount)
+	}
+	if groupID, ok := m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		msg.GroupID = aws.StringValue(groupID)
+	}
+	unwrapSNSNotification(msg)
+	return msg
+}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS is a minimal SQS query API endpoint. Handlers are keyed by action
+// and write the XML response body.
+type fakeSQS struct {
+	*httptest.Server
+	handlers map[string]func(w http.ResponseWriter, r *http.Request)
+
+	calls map[string]int
+	mu    sync.Mutex
+}
+
+func newFakeSQS(t *testing.T) *fakeSQS {
+	f := &fakeSQS{
+		handlers: map[string]func(w http.ResponseWriter, r *http.Request){},
+		calls:    map[string]int{},
+	}
+	f.handlers["GetQueueUrl"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<GetQueueUrlResponse><GetQueueUrlResult><QueueUrl>%s/123/%s</QueueUrl></GetQueueUrlResult></GetQueueUrlResponse>`, f.URL, r.FormValue("QueueName"))
+	}
+	f.handlers["SendMessage"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<SendMessageResponse><SendMessageResult><MessageId>1</MessageId></SendMessageResult></SendMessageResponse>`)
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		action := r.FormValue("Action")
+		f.mu.Lock()
+		f.calls[action]++
+		h := f.handlers[action]
+		f.mu.Unlock()
+		if h == nil {
+			t.Errorf("unexpected SQS action %s", action)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSQS) handle(action string, h func(w http.ResponseWriter, r *http.Request)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[action] = h
+}
+
+func (f *fakeSQS) count(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[action]
+}
+
+func (f *fakeSQS) session() *session.Session {
+	return session.Must(session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(f.URL).
+		WithDisableComputeChecksums(true).
+		WithMaxRetries(0)))
+}
+
+func TestSQSQueueCachesQueueURL(t *testing.T) {
+	f := newFakeSQS(t)
+	q := NewSQSQueue(f.session())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"}))
+	}
+	assert.Equal(t, 1, f.count("GetQueueUrl"))
+	assert.Equal(t, 3, f.count("SendMessage"))
+
+	f.handle("SendMessage", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue</Code><Message>The specified queue does not exist.</Message></Error></ErrorResponse>`)
+	})
+	require.Error(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"}))
+	require.Error(t, q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"}))
+	assert.Equal(t, 2, f.count("GetQueueUrl"))
+}
+
+func TestSQSQueueSendBatch(t *testing.T) {
+	f := newFakeSQS(t)
+	q := NewSQSQueue(f.session())
+
+	attempts := map[string]int{}
+	f.handle("SendMessageBatch", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult>`)
+		for n := 1; r.FormValue(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", n)) != ""; n++ {
+			id := r.FormValue(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", n))
+			attempts[id]++
+			switch {
+			case id == "1" && attempts[id] == 1:
+				fmt.Fprintf(w, `<BatchResultErrorEntry><Id>%s</Id><Code>InternalError</Code><Message>try again</Message><SenderFault>false</SenderFault></BatchResultErrorEntry>`, id)
+			case id == "2":
+				fmt.Fprintf(w, `<BatchResultErrorEntry><Id>%s</Id><Code>InvalidMessageContents</Code><Message>bad</Message><SenderFault>true</SenderFault></BatchResultErrorEntry>`, id)
+			default:
+				fmt.Fprintf(w, `<SendMessageBatchResultEntry><Id>%s</Id><MessageId>m-%s</MessageId></SendMessageBatchResultEntry>`, id, id)
+			}
+		}
+		fmt.Fprint(w, `</SendMessageBatchResult></SendMessageBatchResponse>`)
+	})
+
+	msgs := make([]*SQSMessage, 12)
+	for i := range msgs {
+		msgs[i] = &SQSMessage{Action: "a", Body: "{}"}
+	}
+	err := q.SendBatch(context.Background(), "test-queue", msgs)
+
+	require.IsType(t, &BatchError{}, err)
+	failures := err.(*BatchError).Failures
+	require.Len(t, failures, 1)
+	assert.Equal(t, 2, failures[0].Index)
+
+	// two requests for 12 entries, then one retrying entry 1 only
+	assert.Equal(t, 3, f.count("SendMessageBatch"))
+	assert.Equal(t, 2, attempts["1"])
+	assert.Equal(t, 1, attempts["0"])
+}
+
+func TestSQSBatchChunks(t *testing.T) {
+	big := &SQSMessage{Action: "a", Body: string(make([]byte, 100*1024))}
+	small := &SQSMessage{Action: "a", Body: "{}"}
+	msgs := []*SQSMessage{big, big, big, small, small}
+
+	chunks := sqsBatchChunks(msgs, []int{0, 1, 2, 3, 4})
+	assert.Equal(t, [][]int{{0, 1}, {2, 3, 4}}, chunks)
+}
+
+func TestSQSQueueRetriesThrottling(t *testing.T) {
+	f := newFakeSQS(t)
+	q := NewSQSQueue(f.session())
+	q.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	f.handle("SendMessage", func(w http.ResponseWriter, r *http.Request) {
+		if f.count("SendMessage") < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>RequestThrottled</Code><Message>slow down</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<SendMessageResponse><SendMessageResult><MessageId>1</MessageId></SendMessageResult></SendMessageResponse>`)
+	})
+
+	require.NoError(t, q.Send(context.Background(), "test-queue", &SQSMessage{Action: "a", Body: "{}"}))
+	assert.Equal(t, 3, f.count("SendMessage"))
+}
+
+func TestSQSQueueTypedErrors(t *testing.T) {
+	f := newFakeSQS(t)
+	q := NewSQSQueue(f.session())
+	q.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	ctx := context.Background()
+
+	f.handle("GetQueueUrl", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue</Code><Message>The specified queue does not exist.</Message></Error></ErrorResponse>`)
+	})
+	err := q.Send(ctx, "missing", &SQSMessage{Action: "a", Body: "{}"})
+	var notFound ErrQueueNotFound
+	require.True(t, errors.As(err, &notFound), err)
+	assert.Equal(t, "missing", notFound.QueueName)
+	assert.Equal(t, 1, f.count("GetQueueUrl"))
+
+	f.handle("GetQueueUrl", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code><Message>The security token included in the request is invalid.</Message></Error></ErrorResponse>`)
+	})
+	err = q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"})
+	assert.True(t, errors.As(err, &ErrInvalidCredentials{}), err)
+
+	err = q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: strings.Repeat("x", sqsMaxMessageSize)})
+	var tooLarge ErrPayloadTooLarge
+	require.True(t, errors.As(err, &tooLarge), err)
+	assert.Equal(t, sqsMaxMessageSize, tooLarge.Limit)
+}
+
+func TestSQSQueueContextCanceledDuringBackoff(t *testing.T) {
+	f := newFakeSQS(t)
+	q := NewSQSQueue(f.session())
+	q.Retry = RetryPolicy{MaxAttempts: 10, BaseDelay: time.Minute, MaxDelay: time.Minute}
+
+	f.handle("SendMessage", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Receiver</Type><Code>InternalError</Code><Message>oops</Message></Error></ErrorResponse>`)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := q.Send(ctx, "test-queue", &SQSMessage{Action: "a", Body: "{}"})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 1, f.count("SendMessage"))
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 )
 
+// AesEncrypt encrypts text with AES in CFB mode. The ciphertext is not
+// authenticated; use Encrypt for new values.
 func AesEncrypt(key []byte, text string) (string, error) {
 	textBytes := []byte(text)
 
@@ -27,8 +29,13 @@ func AesEncrypt(key []byte, text string) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
+// AesDecrypt decrypts a ciphertext written by AesEncrypt. Use Decrypt, which
+// reads both formats, when migrating to Encrypt.
 func AesDecrypt(key []byte, text string) (string, error) {
 	textBytes, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// envelopeVersionPrefix marks ciphertexts written by EnvelopeEncrypt.
+const envelopeVersionPrefix = "e1:"
+
+// KeyEncryptionKey wraps and unwraps the data keys of envelope encryption. The
+// key itself never leaves the implementation, such as a KMS key.
+type KeyEncryptionKey interface {
+	// WrapKey encrypts a data key.
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key returned by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// EnvelopeEncrypt encrypts plaintext with a new AES-256-GCM data key and
+// stores the data key, wrapped by kek, alongside the ciphertext.
+// associatedData is authenticated as with Encrypt.
+func EnvelopeEncrypt(ctx context.Context, kek KeyEncryptionKey, plaintext, associatedData []byte) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := kek.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrap data key: %w", err)
+	}
+	ciphertext, err := encryptGCM(dataKey, "", plaintext, associatedData)
+	if err != nil {
+		return "", err
+	}
+	return envelopeVersionPrefix + base64.StdEncoding.EncodeToString(wrapped) + ":" + strings.TrimPrefix(ciphertext, gcmVersionPrefix), nil
+}
+
+// EnvelopeDecrypt decrypts a ciphertext written by EnvelopeEncrypt, unwrapping
+// its data key with kek.
+func EnvelopeDecrypt(ctx context.Context, kek KeyEncryptionKey, ciphertext string, associatedData []byte) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, envelopeVersionPrefix) {
+		return nil, ErrUnsupportedVersion
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ciphertext, envelopeVersionPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid envelope ciphertext")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := kek.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return decryptGCM(dataKey, parts[1], associatedData)
+}
+
+// KMSKeyEncryptionKey wraps data keys with an AWS KMS key. The region is read
+// from AWS_REGION and KMS_ENDPOINT can point it at a local stand-in.
+type KMSKeyEncryptionKey struct {
+	// EncryptionContext is passed to KMS with every request. Data keys can
+	// only be unwrapped with the context they were wrapped with.
+	EncryptionContext map[string]string
+
+	keyID string
+	svc   *kms.KMS
+}
+
+// NewKMSKeyEncryptionKey returns a KeyEncryptionKey using the KMS key with
+// the given ID, ARN or alias. If sess is nil, a session is created from the
+// environment.
+func NewKMSKeyEncryptionKey(sess *session.Session, keyID string) (*KMSKeyEncryptionKey, error) {
+	if sess == nil {
+		var err error
+		if sess, err = session.NewSession(); err != nil {
+			return nil, err
+		}
+	}
+	config := aws.NewConfig()
+	if endpoint := os.Getenv("KMS_ENDPOINT"); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	return &KMSKeyEncryptionKey{
+		keyID: keyID,
+		svc:   kms.New(sess, config),
+	}, nil
+}
+
+func (k *KMSKeyEncryptionKey) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	output, err := k.svc.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(k.keyID),
+		Plaintext:         dataKey,
+		EncryptionContext: aws.StringMap(k.EncryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.CiphertextBlob, nil
+}
+
+func (k *KMSKeyEncryptionKey) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	output, err := k.svc.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(k.keyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: aws.StringMap(k.EncryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+// FileKeyEncryptionKey wraps data keys with an AES-256 key read from a local
+// file. It stands in for KMSKeyEncryptionKey in development and tests.
+type FileKeyEncryptionKey struct {
+	key []byte
+}
+
+// NewFileKeyEncryptionKey reads a base64 encoded 32-byte key from path, as
+// written by WriteKeyFile.
+func NewFileKeyEncryptionKey(path string) (*FileKeyEncryptionKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key file %s: %w", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key file %s: %w", path, ErrInvalidKeySize)
+	}
+	return &FileKeyEncryptionKey{key: key}, nil
+}
+
+// WriteKeyFile writes a new random key for NewFileKeyEncryptionKey to path.
+// It fails if the file exists.
+func WriteKeyFile(path string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, base64.StdEncoding.EncodeToString(key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (k *FileKeyEncryptionKey) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	ciphertext, err := encryptGCM(k.key, "", dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ciphertext), nil
+}
+
+func (k *FileKeyEncryptionKey) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	_, payload, err := parseCiphertext(string(wrapped))
+	if err != nil {
+		return nil, err
+	}
+	return decryptGCM(k.key, payload, nil)
+}
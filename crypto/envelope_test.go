@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestEnvelopeEncryptFileKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := WriteKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyFile(path); err == nil {
+		t.Error("overwrote existing key file")
+	}
+	kek, err := NewFileKeyEncryptionKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	ciphertext, err := EnvelopeEncrypt(ctx, kek, []byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := EnvelopeEncrypt(ctx, kek, []byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.SplitN(ciphertext, ":", 3)[1] == strings.SplitN(other, ":", 3)[1] {
+		t.Error("data key was reused")
+	}
+
+	plaintext, err := EnvelopeDecrypt(ctx, kek, ciphertext, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("%q != %q", plaintext, "secret")
+	}
+	if _, err := EnvelopeDecrypt(ctx, kek, ciphertext, []byte("other")); err != ErrDecrypt {
+		t.Errorf("decrypt with other associated data: %v", err)
+	}
+
+	otherPath := filepath.Join(t.TempDir(), "kek")
+	if err := WriteKeyFile(otherPath); err != nil {
+		t.Fatal(err)
+	}
+	otherKEK, err := NewFileKeyEncryptionKey(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnvelopeDecrypt(ctx, otherKEK, ciphertext, []byte("ad")); err == nil {
+		t.Error("decrypted with other key encryption key")
+	}
+}
+
+func TestEnvelopeEncryptKMS(t *testing.T) {
+	var targets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		targets = append(targets, target)
+		var input map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Error(err)
+		}
+		if input["KeyId"] != "alias/test" {
+			t.Errorf("unexpected key ID %v", input["KeyId"])
+		}
+		// the fake "wraps" keys by passing them through
+		switch target {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(map[string]interface{}{"CiphertextBlob": input["Plaintext"], "KeyId": "alias/test"})
+		case "TrentService.Decrypt":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Plaintext": input["CiphertextBlob"], "KeyId": "alias/test"})
+		default:
+			t.Errorf("unexpected KMS target %s", target)
+		}
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithMaxRetries(0)))
+	kek, err := NewKMSKeyEncryptionKey(sess, "alias/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	ciphertext, err := EnvelopeEncrypt(ctx, kek, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := EnvelopeDecrypt(ctx, kek, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("%q != %q", plaintext, "secret")
+	}
+	if strings.Join(targets, ",") != "TrentService.Encrypt,TrentService.Decrypt" {
+		t.Errorf("unexpected KMS calls %v", targets)
+	}
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// gcmVersionPrefix marks ciphertexts written by Encrypt. Legacy ciphertexts
+// written by AesEncrypt are plain base64, which never contains a colon.
+const gcmVersionPrefix = "v1:"
+
+var (
+	// ErrInvalidKeySize is returned when a key for Encrypt is not 32 bytes.
+	ErrInvalidKeySize = errors.New("key must be 32 bytes for AES-256")
+	// ErrDecrypt is returned when a ciphertext was tampered with, or was
+	// encrypted with a different key or associated data.
+	ErrDecrypt = errors.New("message authentication failed")
+	// ErrUnsupportedVersion is returned for ciphertexts written by a newer
+	// version of Encrypt.
+	ErrUnsupportedVersion = errors.New("unsupported ciphertext version")
+)
+
+// Encrypt encrypts plaintext with AES-256-GCM. associatedData, which may be
+// nil, is authenticated but not encrypted, and the same value must be passed
+// to Decrypt. This binds a ciphertext to its context, such as the ID of the
+// row or the name of the column it is stored in. The result is a versioned
+// string safe to store in text columns.
+func Encrypt(key, plaintext, associatedData []byte) (string, error) {
+	return encryptGCM(key, "", plaintext, associatedData)
+}
+
+// Decrypt decrypts a ciphertext written by Encrypt or a Keyring, failing with
+// ErrDecrypt if it does not authenticate. Legacy ciphertexts written by
+// AesEncrypt are decrypted too, so that stored values can be migrated
+// gradually; they are not authenticated and associatedData is ignored for
+// them. Use IsLegacyCiphertext to find values to re-encrypt.
+func Decrypt(key []byte, ciphertext string, associatedData []byte) ([]byte, error) {
+	if IsLegacyCiphertext(ciphertext) {
+		plaintext, err := AesDecrypt(key, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(plaintext), nil
+	}
+	_, payload, err := parseCiphertext(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return decryptGCM(key, payload, associatedData)
+}
+
+// IsLegacyCiphertext reports whether ciphertext was written by AesEncrypt
+// rather than Encrypt.
+func IsLegacyCiphertext(ciphertext string) bool {
+	return !strings.Contains(ciphertext, ":")
+}
+
+// encryptGCM returns the versioned ciphertext of plaintext, including keyID
+// when it is not empty.
+func encryptGCM(key []byte, keyID string, plaintext, associatedData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, associatedData)
+	if keyID != "" {
+		keyID += ":"
+	}
+	return gcmVersionPrefix + keyID + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptGCM(key []byte, payload string, associatedData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("Ciphertext too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// parseCiphertext splits a versioned ciphertext into its key ID, which is
+// empty if it has none, and its base64 payload.
+func parseCiphertext(ciphertext string) (string, string, error) {
+	if !strings.HasPrefix(ciphertext, gcmVersionPrefix) {
+		return "", "", ErrUnsupportedVersion
+	}
+	rest := strings.TrimPrefix(ciphertext, gcmVersionPrefix)
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		return rest[:i], rest[i+1:], nil
+	}
+	return "", rest, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
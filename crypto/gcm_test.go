@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	ciphertext, err := Encrypt(key, []byte("secret"), []byte("users.email"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ciphertext, "v1:") || IsLegacyCiphertext(ciphertext) {
+		t.Errorf("unexpected ciphertext format %q", ciphertext)
+	}
+
+	plaintext, err := Decrypt(key, ciphertext, []byte("users.email"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("%q != %q", plaintext, "secret")
+	}
+
+	if _, err := Decrypt(key, ciphertext, []byte("users.name")); err != ErrDecrypt {
+		t.Errorf("decrypt with other associated data: %v", err)
+	}
+	if _, err := Decrypt(bytes.Repeat([]byte{2}, 32), ciphertext, []byte("users.email")); err != ErrDecrypt {
+		t.Errorf("decrypt with other key: %v", err)
+	}
+
+	tampered := []byte(ciphertext)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := Decrypt(key, string(tampered), []byte("users.email")); err == nil {
+		t.Error("decrypted tampered ciphertext")
+	}
+	if _, err := Decrypt(key, "v2:AAAA", nil); err != ErrUnsupportedVersion {
+		t.Errorf("decrypt unknown version: %v", err)
+	}
+	if _, err := Encrypt(key[:16], []byte("secret"), nil); err != ErrInvalidKeySize {
+		t.Errorf("encrypt with short key: %v", err)
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	ciphertext, err := AesEncrypt(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLegacyCiphertext(ciphertext) {
+		t.Errorf("%q is not detected as legacy", ciphertext)
+	}
+
+	plaintext, err := Decrypt(key, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("%q != %q", plaintext, "secret")
+	}
+
+	if _, err := AesDecrypt(key, "not base64!"); err == nil {
+		t.Error("decrypted invalid base64")
+	}
+}
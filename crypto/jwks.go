@@ -0,0 +1,251 @@
+package crypto
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JWK is a public key in a JWKS document (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 curve and public key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JWKS document listing public keys. It is a JWTKeySource.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWKS returns the document listing the public parts of keys. HMAC keys
+// have no public part and are left out.
+func NewJWKS(keys ...*JWTKey) *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, k := range keys {
+		jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+		switch public := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func (s *JWKS) JWTKey(ctx context.Context, id string) (*JWTKey, error) {
+	for _, jwk := range s.Keys {
+		if jwk.KeyID == id {
+			return jwk.key()
+		}
+	}
+	return nil, ErrJWTKeyNotFound
+}
+
+func (jwk JWK) key() (*JWTKey, error) {
+	key, err := jwk.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != key.Algorithm {
+		return nil, errors.Errorf("unsupported algorithm %s of key %s", jwk.Algorithm, jwk.KeyID)
+	}
+	return key, nil
+}
+
+func (jwk JWK) publicKey() (*JWTKey, error) {
+	switch {
+	case jwk.KeyType == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode key %s", jwk.KeyID)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.Errorf("invalid exponent of key %s", jwk.KeyID)
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return &JWTKey{ID: jwk.KeyID, Algorithm: JWTAlgorithmRS256, public: public}, nil
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid public key %s", jwk.KeyID)
+		}
+		return &JWTKey{ID: jwk.KeyID, Algorithm: JWTAlgorithmEdDSA, public: ed25519.PublicKey(x)}, nil
+	}
+	return nil, errors.Errorf("unsupported key type %s of key %s", jwk.KeyType, jwk.KeyID)
+}
+
+// JWKSHandler serves the JWKS document of keys, typically at
+// /.well-known/jwks.json. Use gin.WrapH to mount it on a gin router.
+func JWKSHandler(keys ...*JWTKey) http.Handler {
+	body, _ := json.Marshal(NewJWKS(keys...))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(body)
+	})
+}
+
+// remoteJWKSMinRefresh limits how often unknown key IDs trigger a refresh.
+const remoteJWKSMinRefresh = time.Minute
+
+// DefaultJWKSTTL is how long a RemoteJWKS without a TTL caches the document.
+const DefaultJWKSTTL = time.Hour
+
+// RemoteJWKS is a JWTKeySource fetching a JWKS document over HTTP. The
+// document is cached for TTL, and refreshed early, at most once a minute,
+// when a token has an unknown key ID, so that key rotations are picked up.
+// Concurrent lookups share a single fetch, and when a refresh fails the
+// cached keys keep being used and the next attempt waits a minute.
+type RemoteJWKS struct {
+	URL string
+	// TTL defaults to DefaultJWKSTTL.
+	TTL time.Duration
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	jwks        *JWKS
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+	// fetching is closed when the fetch in flight, if any, completes
+	fetching chan struct{}
+	sync.Mutex
+}
+
+// NewRemoteJWKS returns a RemoteJWKS caching the document at url for ttl.
+func NewRemoteJWKS(url string, ttl time.Duration) *RemoteJWKS {
+	return &RemoteJWKS{URL: url, TTL: ttl}
+}
+
+func (r *RemoteJWKS) JWTKey(ctx context.Context, id string) (*JWTKey, error) {
+	r.Lock()
+	jwks, fetchedAt := r.jwks, r.fetchedAt
+	r.Unlock()
+
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = DefaultJWKSTTL
+	}
+	if jwks == nil || time.Since(fetchedAt) > ttl {
+		fresh, err := r.refresh(ctx, 0)
+		if fresh == nil {
+			return nil, err
+		}
+		jwks = fresh
+	}
+	key, err := jwks.JWTKey(ctx, id)
+	if err != ErrJWTKeyNotFound {
+		return key, err
+	}
+	fresh, _ := r.refresh(ctx, remoteJWKSMinRefresh)
+	if fresh == nil || fresh == jwks {
+		return nil, err
+	}
+	return fresh.JWTKey(ctx, id)
+}
+
+// refresh fetches the document, unless the last attempt was less than
+// minInterval ago, or failed less than remoteJWKSMinRefresh ago. If a fetch
+// is already in flight it waits for it instead. It returns the cached
+// document, which is the previous one if the fetch failed, along with the
+// error of the last attempt.
+func (r *RemoteJWKS) refresh(ctx context.Context, minInterval time.Duration) (*JWKS, error) {
+	r.Lock()
+	if fetching := r.fetching; fetching != nil {
+		r.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		r.Lock()
+		jwks, err := r.jwks, r.fetchErr
+		r.Unlock()
+		if jwks == nil && err == nil {
+			// the fetch was abandoned by its caller
+			return r.refresh(ctx, minInterval)
+		}
+		return jwks, err
+	}
+	since := time.Since(r.attemptedAt)
+	if since < minInterval || (r.fetchErr != nil && since < remoteJWKSMinRefresh) {
+		defer r.Unlock()
+		return r.jwks, r.fetchErr
+	}
+	fetching := make(chan struct{})
+	r.fetching = fetching
+	previousAttempt := r.attemptedAt
+	r.attemptedAt = time.Now()
+	r.Unlock()
+
+	jwks, err := r.fetch(ctx)
+
+	r.Lock()
+	defer r.Unlock()
+	if err != nil && ctx.Err() != nil {
+		// the caller gave up, which says nothing about the remote
+		r.attemptedAt = previousAttempt
+	} else {
+		r.fetchErr = err
+	}
+	if err == nil {
+		r.jwks = jwks
+		r.fetchedAt = r.attemptedAt
+	}
+	r.fetching = nil
+	close(fetching)
+	return r.jwks, err
+}
+
+func (r *RemoteJWKS) fetch(ctx context.Context) (*JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch JWKS")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, errors.Wrap(err, "decode JWKS")
+	}
+	return &jwks, nil
+}
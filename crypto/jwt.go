@@ -0,0 +1,288 @@
+package crypto
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	// ErrJWTMalformed is returned for tokens that cannot be decoded.
+	ErrJWTMalformed = errors.New("malformed token")
+	// ErrJWTSignature is returned when the signature of a token does not
+	// verify with its key.
+	ErrJWTSignature = errors.New("token signature is invalid")
+	// ErrJWTKeyNotFound is returned when no key matches the key ID and
+	// algorithm of a token.
+	ErrJWTKeyNotFound = errors.New("token key not found")
+	// ErrJWTExpired is returned for tokens past their expiration time.
+	ErrJWTExpired = errors.New("token is expired")
+	// ErrJWTNotYetValid is returned for tokens before their not-before time.
+	ErrJWTNotYetValid = errors.New("token is not valid yet")
+	// ErrJWTIssuer is returned for tokens of another issuer.
+	ErrJWTIssuer = errors.New("token issuer is not accepted")
+	// ErrJWTAudience is returned for tokens for another audience.
+	ErrJWTAudience = errors.New("token audience is not accepted")
+)
+
+// JWTClaims are the registered claims of a token. Embed it in a struct with
+// the custom claims of a token.
+type JWTClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// JWTAudience is the aud claim, which is either a string or an array.
+type JWTAudience []string
+
+func (a JWTAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *JWTAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = JWTAudience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// JWTKey is a key tokens are signed or verified with. Keys holding a private
+// key or an HMAC secret can sign; public keys, such as the ones read from a
+// JWKS, can only verify.
+type JWTKey struct {
+	// ID is the key ID written to the kid header of tokens.
+	ID        string
+	Algorithm string
+
+	secret  []byte
+	private gocrypto.Signer
+	public  gocrypto.PublicKey
+}
+
+// NewHS256Key returns a key signing with HMAC-SHA256.
+func NewHS256Key(id string, secret []byte) *JWTKey {
+	return &JWTKey{ID: id, Algorithm: JWTAlgorithmHS256, secret: secret}
+}
+
+// NewRS256Key returns a key signing with RSASSA-PKCS1-v1_5 and SHA-256.
+func NewRS256Key(id string, key *rsa.PrivateKey) *JWTKey {
+	return &JWTKey{ID: id, Algorithm: JWTAlgorithmRS256, private: key, public: &key.PublicKey}
+}
+
+// NewEdDSAKey returns a key signing with Ed25519.
+func NewEdDSAKey(id string, key ed25519.PrivateKey) *JWTKey {
+	return &JWTKey{ID: id, Algorithm: JWTAlgorithmEdDSA, private: key, public: key.Public()}
+}
+
+// Public returns the key without its private part. It returns nil for HMAC
+// keys, which have no public part.
+func (k *JWTKey) Public() *JWTKey {
+	if k.public == nil {
+		return nil
+	}
+	return &JWTKey{ID: k.ID, Algorithm: k.Algorithm, public: k.public}
+}
+
+func (k *JWTKey) sign(input []byte) ([]byte, error) {
+	switch {
+	case k.Algorithm == JWTAlgorithmHS256 && k.secret != nil:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case k.Algorithm == JWTAlgorithmRS256 && k.private != nil:
+		digest := sha256.Sum256(input)
+		return k.private.Sign(rand.Reader, digest[:], gocrypto.SHA256)
+	case k.Algorithm == JWTAlgorithmEdDSA && k.private != nil:
+		return k.private.Sign(rand.Reader, input, gocrypto.Hash(0))
+	}
+	return nil, errors.Errorf("key %s cannot sign %s tokens", k.ID, k.Algorithm)
+}
+
+func (k *JWTKey) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case JWTAlgorithmHS256:
+		if k.secret == nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case JWTAlgorithmRS256:
+		public, ok := k.public.(*rsa.PublicKey)
+		digest := sha256.Sum256(input)
+		return ok && rsa.VerifyPKCS1v15(public, gocrypto.SHA256, digest[:], signature) == nil
+	case JWTAlgorithmEdDSA:
+		public, ok := k.public.(ed25519.PublicKey)
+		return ok && ed25519.Verify(public, input, signature)
+	}
+	return false
+}
+
+// JWTKeySource looks up the key a token was signed with by its key ID.
+type JWTKeySource interface {
+	JWTKey(ctx context.Context, id string) (*JWTKey, error)
+}
+
+// JWTKeys is a local JWTKeySource. A token without a key ID matches a key
+// without one.
+type JWTKeys []*JWTKey
+
+func (keys JWTKeys) JWTKey(ctx context.Context, id string) (*JWTKey, error) {
+	for _, k := range keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return nil, ErrJWTKeyNotFound
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// IssueJWT returns a token of claims signed with key. claims is marshalled to
+// JSON and usually embeds JWTClaims.
+func IssueJWT(claims interface{}, key *JWTKey) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal claims")
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// JWTValidation are the checks ParseJWT applies on top of the signature.
+type JWTValidation struct {
+	// Issuer, if set, must equal the iss claim.
+	Issuer string
+	// Audience, if set, must be one of the aud claim.
+	Audience string
+	// ClockSkew is the leeway allowed for exp and nbf.
+	ClockSkew time.Duration
+	// RequireExpiration rejects tokens without an exp claim.
+	RequireExpiration bool
+}
+
+// ParseJWT verifies a token with the key matching its key ID and algorithm
+// and checks its registered claims, then unmarshals its claims into v, which
+// may be nil. Errors that stem from the token are one of the ErrJWT values,
+// possibly wrapped.
+func ParseJWT(ctx context.Context, token string, keys JWTKeySource, validation JWTValidation, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrJWTMalformed
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrJWTMalformed
+	}
+
+	key, err := keys.JWTKey(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+	// the algorithm is taken from the key, never from the token alone
+	if key.Algorithm != header.Algorithm {
+		return ErrJWTKeyNotFound
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrJWTSignature
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+	if err := validation.check(claims, time.Now()); err != nil {
+		return err
+	}
+	if v != nil {
+		return decodeJWTPart(parts[1], v)
+	}
+	return nil
+}
+
+func (v JWTValidation) check(claims JWTClaims, now time.Time) error {
+	if claims.ExpiresAt == 0 && v.RequireExpiration {
+		return ErrJWTExpired
+	}
+	if claims.ExpiresAt != 0 && !now.Add(-v.ClockSkew).Before(time.Unix(claims.ExpiresAt, 0)) {
+		return ErrJWTExpired
+	}
+	if claims.NotBefore != 0 && now.Add(v.ClockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrJWTNotYetValid
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return ErrJWTIssuer
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			if aud == v.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrJWTAudience
+		}
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(ErrJWTMalformed, err.Error())
+	}
+	return nil
+}
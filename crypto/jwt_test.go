@@ -0,0 +1,302 @@
+package crypto
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type sessionClaims struct {
+	JWTClaims
+	TeamID string `json:"team_id"`
+}
+
+func newTestJWTKeys(t *testing.T) (*JWTKey, *JWTKey, *JWTKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHS256Key("hs", []byte("secret")), NewRS256Key("rs", rsaKey), NewEdDSAKey("ed", edKey)
+}
+
+func TestJWTIssueParse(t *testing.T) {
+	hs, rs, ed := newTestJWTKeys(t)
+	ctx := context.Background()
+	now := time.Now()
+	claims := sessionClaims{
+		JWTClaims: JWTClaims{
+			Issuer:    "vendor",
+			Subject:   "user-1",
+			Audience:  JWTAudience{"api"},
+			ExpiresAt: now.Add(time.Hour).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		TeamID: "team-1",
+	}
+	validation := JWTValidation{Issuer: "vendor", Audience: "api", RequireExpiration: true}
+
+	for _, key := range []*JWTKey{hs, rs, ed} {
+		token, err := IssueJWT(claims, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var parsed sessionClaims
+		verifier := key.Public()
+		if verifier == nil {
+			verifier = key
+		}
+		if err := ParseJWT(ctx, token, JWTKeys{verifier}, validation, &parsed); err != nil {
+			t.Fatalf("%s: %v", key.Algorithm, err)
+		}
+		if parsed.Subject != "user-1" || parsed.TeamID != "team-1" || len(parsed.Audience) != 1 {
+			t.Errorf("%s: unexpected claims %+v", key.Algorithm, parsed)
+		}
+
+		parts := strings.Split(token, ".")
+		tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+		if err := ParseJWT(ctx, tampered, JWTKeys{verifier}, JWTValidation{}, nil); err != ErrJWTSignature {
+			t.Errorf("%s: tampered token: %v", key.Algorithm, err)
+		}
+	}
+
+	// a token claiming HS256 must not be verified with an RSA key
+	forged := NewHS256Key("rs", []byte("public key bytes"))
+	token, err := IssueJWT(claims, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseJWT(ctx, token, JWTKeys{rs.Public()}, JWTValidation{}, nil); err != ErrJWTKeyNotFound {
+		t.Errorf("algorithm confusion: %v", err)
+	}
+	if _, err := IssueJWT(claims, rs.Public()); err == nil {
+		t.Error("signed with a public key")
+	}
+}
+
+func TestJWTValidation(t *testing.T) {
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		claims     JWTClaims
+		validation JWTValidation
+		err        error
+	}{
+		"valid":              {JWTClaims{ExpiresAt: now.Add(time.Minute).Unix()}, JWTValidation{}, nil},
+		"expired":            {JWTClaims{ExpiresAt: now.Add(-time.Minute).Unix()}, JWTValidation{}, ErrJWTExpired},
+		"expired in skew":    {JWTClaims{ExpiresAt: now.Add(-time.Minute).Unix()}, JWTValidation{ClockSkew: 2 * time.Minute}, nil},
+		"not yet valid":      {JWTClaims{NotBefore: now.Add(time.Minute).Unix()}, JWTValidation{}, ErrJWTNotYetValid},
+		"nbf in skew":        {JWTClaims{NotBefore: now.Add(time.Minute).Unix()}, JWTValidation{ClockSkew: 2 * time.Minute}, nil},
+		"missing expiration": {JWTClaims{}, JWTValidation{RequireExpiration: true}, ErrJWTExpired},
+		"issuer":             {JWTClaims{Issuer: "other"}, JWTValidation{Issuer: "vendor"}, ErrJWTIssuer},
+		"audience":           {JWTClaims{Audience: JWTAudience{"web", "cli"}}, JWTValidation{Audience: "api"}, ErrJWTAudience},
+		"one of audiences":   {JWTClaims{Audience: JWTAudience{"web", "api"}}, JWTValidation{Audience: "api"}, nil},
+	} {
+		if err := tc.validation.check(tc.claims, now); err != tc.err {
+			t.Errorf("%s: %v != %v", name, err, tc.err)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	hs, rs, ed := newTestJWTKeys(t)
+	ctx := context.Background()
+
+	var fetches int32
+	var handler atomic.Value
+	handler.Store(JWKSHandler(hs, rs))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		handler.Load().(http.Handler).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(hs, rs, ed)
+	if len(jwks.Keys) != 2 {
+		t.Errorf("JWKS has %d keys, HMAC keys must be left out", len(jwks.Keys))
+	}
+
+	remote := NewRemoteJWKS(server.URL, time.Hour)
+	for _, key := range []*JWTKey{rs, rs, ed} {
+		token, err := IssueJWT(JWTClaims{Subject: "s"}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ParseJWT(ctx, token, jwks, JWTValidation{}, nil); err != nil {
+			t.Errorf("local %s: %v", key.ID, err)
+		}
+		err = ParseJWT(ctx, token, remote, JWTValidation{}, nil)
+		if key == ed && !errors.Is(err, ErrJWTKeyNotFound) {
+			t.Errorf("remote %s: %v", key.ID, err)
+		} else if key != ed && err != nil {
+			t.Errorf("remote %s: %v", key.ID, err)
+		}
+	}
+	// the document was cached, and the unknown key ID did not refresh it
+	// again within a minute of the first fetch
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("JWKS fetched %d times", n)
+	}
+
+	// a rotated key is picked up once the minimum refresh interval passed
+	handler.Store(JWKSHandler(rs, ed))
+	remote.fetchedAt = time.Now().Add(-2 * time.Minute)
+	remote.attemptedAt = remote.fetchedAt
+	token, err := IssueJWT(JWTClaims{Subject: "s"}, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+}
+
+func TestRemoteJWKSFailures(t *testing.T) {
+	_, rs, ed := newTestJWTKeys(t)
+	ctx := context.Background()
+
+	var fetches int32
+	var down atomic.Value
+	down.Store(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if down.Load().(bool) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		JWKSHandler(rs).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	remote := NewRemoteJWKS(server.URL, time.Minute)
+	token, err := IssueJWT(JWTClaims{Subject: "s"}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached keys keep being used once the TTL expired and the remote is down
+	down.Store(true)
+	remote.fetchedAt = time.Now().Add(-2 * time.Minute)
+	remote.attemptedAt = remote.fetchedAt
+	if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+		t.Errorf("stale keys: %v", err)
+	}
+
+	// unknown key IDs do not refetch on every lookup after a failure
+	unknown, err := IssueJWT(JWTClaims{Subject: "s"}, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := ParseJWT(ctx, unknown, remote, JWTValidation{}, nil); !errors.Is(err, ErrJWTKeyNotFound) {
+			t.Errorf("unknown key: %v", err)
+		}
+		if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+			t.Errorf("stale keys: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("JWKS fetched %d times", n)
+	}
+}
+
+func TestRemoteJWKSDefaultTTL(t *testing.T) {
+	_, rs, _ := newTestJWTKeys(t)
+	ctx := context.Background()
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		JWKSHandler(rs).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	remote := &RemoteJWKS{URL: server.URL}
+	token, err := IssueJWT(JWTClaims{Subject: "s"}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("JWKS fetched %d times", n)
+	}
+}
+
+func TestRemoteJWKSFetchDoesNotBlockLookups(t *testing.T) {
+	_, rs, ed := newTestJWTKeys(t)
+	ctx := context.Background()
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		JWKSHandler(rs, ed).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	remote := NewRemoteJWKS(server.URL, time.Hour)
+	token, err := IssueJWT(JWTClaims{Subject: "s"}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseJWT(ctx, token, remote, JWTValidation{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a refresh for an unknown key ID hangs on the remote
+	remote.Lock()
+	remote.jwks = NewJWKS(rs)
+	remote.attemptedAt = time.Now().Add(-2 * time.Minute)
+	remote.Unlock()
+	unknown, err := IssueJWT(JWTClaims{Subject: "s"}, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- ParseJWT(ctx, unknown, remote, JWTValidation{}, nil)
+	}()
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	lookup := make(chan error)
+	go func() {
+		lookup <- ParseJWT(ctx, token, remote, JWTValidation{}, nil)
+	}()
+	select {
+	case err := <-lookup:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup of a cached key waited for the fetch")
+	}
+
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Keyring holds named AES-256 keys. It encrypts with the active key and
+// embeds its ID in the ciphertext, so that the active key can be rotated
+// without re-encrypting stored values at once: values encrypted with older
+// keys keep decrypting until a background job re-encrypts them with
+// Reencrypt.
+//
+// Values written by Encrypt or AesEncrypt carry no key ID. They are decrypted
+// with the key registered under the empty ID, if any.
+//
+// The zero value is an empty keyring; add a key and make it active before
+// encrypting.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+	sync.RWMutex
+}
+
+// NewKeyring returns a keyring holding keys, encrypting with the key named
+// active.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	for id, key := range keys {
+		if err := k.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.SetActive(active); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds a key, replacing any key with the same ID. Key IDs cannot contain
+// colons. Only the key registered under the empty ID may be shorter than 32
+// bytes, to decrypt legacy AesEncrypt values.
+func (k *Keyring) Add(id string, key []byte) error {
+	if strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	if id != "" && len(key) != 32 {
+		return fmt.Errorf("key %s: %w", id, ErrInvalidKeySize)
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	if k.keys == nil {
+		k.keys = map[string][]byte{}
+	}
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetActive makes the key named id the one new values are encrypted with.
+func (k *Keyring) SetActive(id string) error {
+	k.Lock()
+	defer k.Unlock()
+	if id == "" {
+		return fmt.Errorf("active key ID is required")
+	}
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("key %s not found", id)
+	}
+	k.active = id
+	return nil
+}
+
+// ActiveKeyID returns the ID of the key new values are encrypted with.
+func (k *Keyring) ActiveKeyID() string {
+	k.RLock()
+	defer k.RUnlock()
+	return k.active
+}
+
+// Encrypt encrypts plaintext with the active key like Encrypt.
+func (k *Keyring) Encrypt(plaintext, associatedData []byte) (string, error) {
+	k.RLock()
+	id, key := k.active, k.keys[k.active]
+	k.RUnlock()
+	return encryptGCM(key, id, plaintext, associatedData)
+}
+
+// Decrypt decrypts a ciphertext written by the keyring, Encrypt or
+// AesEncrypt with the matching key.
+func (k *Keyring) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+	id, err := KeyID(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	k.RLock()
+	key, ok := k.keys[id]
+	k.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", id)
+	}
+	return Decrypt(key, ciphertext, associatedData)
+}
+
+// NeedsReencrypt reports whether ciphertext was not written with the active
+// key in the current format.
+func (k *Keyring) NeedsReencrypt(ciphertext string) bool {
+	id, err := KeyID(ciphertext)
+	return err != nil || IsLegacyCiphertext(ciphertext) || id != k.ActiveKeyID()
+}
+
+// Reencrypt decrypts ciphertext and encrypts it again with the active key. It
+// returns ciphertext unchanged and false if it does not need re-encryption.
+// The associated data must be the one the value was encrypted with.
+func (k *Keyring) Reencrypt(ciphertext string, associatedData []byte) (string, bool, error) {
+	if !k.NeedsReencrypt(ciphertext) {
+		return ciphertext, false, nil
+	}
+	plaintext, err := k.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		return "", false, err
+	}
+	reencrypted, err := k.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return "", false, err
+	}
+	return reencrypted, true, nil
+}
+
+// KeyID returns the ID of the key a ciphertext was written with, which is
+// empty for ciphertexts written by Encrypt or AesEncrypt.
+func KeyID(ciphertext string) (string, error) {
+	if IsLegacyCiphertext(ciphertext) {
+		return "", nil
+	}
+	id, _, err := parseCiphertext(ciphertext)
+	return id, err
+}
@@ -0,0 +1,125 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 32)
+	legacyKey := bytes.Repeat([]byte{3}, 16)
+
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": k1, "": legacyKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := keyring.Encrypt([]byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := KeyID(old); id != "k1" {
+		t.Errorf("key ID %q != %q", id, "k1")
+	}
+	legacy, err := AesEncrypt(legacyKey, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Add("k2", k2); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActive("k2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ciphertext string
+		ad         string
+		plaintext  string
+	}{
+		{old, "ad", "secret"},
+		{legacy, "", "legacy"},
+	} {
+		if !keyring.NeedsReencrypt(tc.ciphertext) {
+			t.Errorf("%q does not need re-encryption", tc.ciphertext)
+		}
+		reencrypted, changed, err := keyring.Reencrypt(tc.ciphertext, []byte(tc.ad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Errorf("%q was not re-encrypted", tc.ciphertext)
+		}
+		if id, _ := KeyID(reencrypted); id != "k2" {
+			t.Errorf("key ID %q != %q", id, "k2")
+		}
+		plaintext, err := keyring.Decrypt(reencrypted, []byte(tc.ad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != tc.plaintext {
+			t.Errorf("%q != %q", plaintext, tc.plaintext)
+		}
+
+		again, changed, err := keyring.Reencrypt(reencrypted, []byte(tc.ad))
+		if err != nil || changed || again != reencrypted {
+			t.Errorf("re-encrypted %q twice: %v", reencrypted, err)
+		}
+	}
+
+	// single-key Decrypt reads keyring ciphertexts
+	plaintext, err := Decrypt(k1, old, []byte("ad"))
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("decrypt keyring ciphertext: %q, %v", plaintext, err)
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	if _, err := NewKeyring("k1", map[string][]byte{"k1": make([]byte, 16)}); err == nil {
+		t.Error("accepted short key")
+	}
+	if _, err := NewKeyring("k1", map[string][]byte{"k:1": make([]byte, 32)}); err == nil {
+		t.Error("accepted key ID with colon")
+	}
+	if _, err := NewKeyring("missing", map[string][]byte{"k1": make([]byte, 32)}); err == nil {
+		t.Error("accepted missing active key")
+	}
+
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeyring("k2", map[string][]byte{"k2": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := other.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Decrypt(ciphertext, nil); err == nil {
+		t.Error("decrypted with unknown key ID")
+	}
+}
+
+func TestKeyringZeroValue(t *testing.T) {
+	var keyring Keyring
+	if err := keyring.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActive("k1"); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := keyring.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := keyring.Decrypt(ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("%q != %q", plaintext, "secret")
+	}
+}
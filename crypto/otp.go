@@ -0,0 +1,327 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OTPAlgorithmSHA1   = "SHA1"
+	OTPAlgorithmSHA256 = "SHA256"
+	OTPAlgorithmSHA512 = "SHA512"
+)
+
+var (
+	// ErrInvalidOTP is returned when a one-time password or recovery code
+	// does not verify.
+	ErrInvalidOTP = errors.New("invalid one-time password")
+	// ErrInvalidOTPSecret is returned for secrets that are not base32.
+	ErrInvalidOTPSecret = errors.New("invalid one-time password secret")
+)
+
+// otpEncoding is the base32 encoding used for secrets by authenticator apps.
+var otpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// OTPOptions configure one-time passwords. The zero value gives the
+// parameters every authenticator app supports, which are also the only ones
+// many of them support.
+type OTPOptions struct {
+	// Algorithm is OTPAlgorithmSHA1, OTPAlgorithmSHA256 or
+	// OTPAlgorithmSHA512. Defaults to OTPAlgorithmSHA1.
+	Algorithm string
+	// Digits is the length of passwords, 6 to 8. Defaults to 6.
+	Digits int
+	// Period is the time step of TOTP passwords. Defaults to 30 seconds.
+	Period time.Duration
+	// Skew is the number of time steps before and after the current one
+	// whose TOTP passwords are accepted too, to allow for clock drift, or the
+	// number of counters after the expected one whose HOTP passwords are
+	// accepted, to allow for passwords generated but never used. Defaults to
+	// 0 for no drift.
+	Skew int
+}
+
+func (o OTPOptions) withDefaults() (OTPOptions, error) {
+	if o.Algorithm == "" {
+		o.Algorithm = OTPAlgorithmSHA1
+	}
+	if o.Digits == 0 {
+		o.Digits = 6
+	}
+	if o.Period == 0 {
+		o.Period = 30 * time.Second
+	}
+	if _, err := o.hash(); err != nil {
+		return o, err
+	}
+	if o.Digits < 6 || o.Digits > 8 {
+		return o, errors.Errorf("one-time passwords must have 6 to 8 digits, got %d", o.Digits)
+	}
+	if o.Period < time.Second {
+		return o, errors.Errorf("one-time password period must be at least a second, got %s", o.Period)
+	}
+	if o.Skew < 0 {
+		return o, errors.Errorf("negative one-time password skew %d", o.Skew)
+	}
+	return o, nil
+}
+
+func (o OTPOptions) hash() (func() hash.Hash, error) {
+	switch o.Algorithm {
+	case OTPAlgorithmSHA1:
+		return sha1.New, nil
+	case OTPAlgorithmSHA256:
+		return sha256.New, nil
+	case OTPAlgorithmSHA512:
+		return sha512.New, nil
+	}
+	return nil, errors.Errorf("unsupported one-time password algorithm %q", o.Algorithm)
+}
+
+// GenerateOTPSecret returns a random 160 bit secret, base32 encoded without
+// padding as expected by authenticator apps.
+func GenerateOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return otpEncoding.EncodeToString(secret), nil
+}
+
+// GenerateHOTP returns the RFC 4226 password for counter.
+func GenerateHOTP(secret string, counter uint64, opts OTPOptions) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, counter, opts), nil
+}
+
+// VerifyHOTP checks code against counter and the opts.Skew counters after
+// it. It returns the counter to store for the next verification, one past
+// the matching counter, or ErrInvalidOTP.
+func VerifyHOTP(secret, code string, counter uint64, opts OTPOptions) (uint64, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i <= opts.Skew; i++ {
+		if otpEqual(hotp(key, counter+uint64(i), opts), code) {
+			return counter + uint64(i) + 1, nil
+		}
+	}
+	return 0, ErrInvalidOTP
+}
+
+// GenerateTOTP returns the RFC 6238 password at t.
+func GenerateTOTP(secret string, t time.Time, opts OTPOptions) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpStep(t, opts), opts), nil
+}
+
+// VerifyTOTP checks code against the password at t and the opts.Skew time
+// steps either side of it. It returns the matching time step or
+// ErrInvalidOTP. A password stays valid for its whole time step, so callers
+// should store the step and reject codes whose step is not after the last
+// one used, to stop passwords being replayed.
+func VerifyTOTP(secret, code string, t time.Time, opts OTPOptions) (uint64, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	step := totpStep(t, opts)
+	for i := -opts.Skew; i <= opts.Skew; i++ {
+		if i < 0 && uint64(-i) > step {
+			continue
+		}
+		if otpEqual(hotp(key, step+uint64(i), opts), code) {
+			return step + uint64(i), nil
+		}
+	}
+	return 0, ErrInvalidOTP
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI of a TOTP secret, to be
+// shown as a QR code for authenticator apps to scan. issuer is the name of
+// the service and account identifies the user, such as their email address.
+func TOTPProvisioningURI(issuer, account, secret string, opts OTPOptions) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	params := otpURIParams(issuer, secret, opts)
+	params.Set("period", strconv.Itoa(int(opts.Period/time.Second)))
+	return otpURI("totp", issuer, account, params), nil
+}
+
+// HOTPProvisioningURI returns the otpauth:// URI of an HOTP secret whose
+// next counter is counter.
+func HOTPProvisioningURI(issuer, account, secret string, counter uint64, opts OTPOptions) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	params := otpURIParams(issuer, secret, opts)
+	params.Set("counter", strconv.FormatUint(counter, 10))
+	return otpURI("hotp", issuer, account, params), nil
+}
+
+func otpURIParams(issuer, secret string, opts OTPOptions) url.Values {
+	params := url.Values{}
+	params.Set("secret", strings.ToUpper(strings.TrimRight(secret, "=")))
+	if issuer != "" {
+		params.Set("issuer", issuer)
+	}
+	params.Set("algorithm", opts.Algorithm)
+	params.Set("digits", strconv.Itoa(opts.Digits))
+	return params
+}
+
+func otpURI(kind, issuer, account string, params url.Values) string {
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     kind,
+		Path:     "/" + label,
+		RawQuery: strings.ReplaceAll(params.Encode(), "+", "%20"),
+	}
+	return u.String()
+}
+
+func decodeOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := otpEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidOTPSecret
+	}
+	return key, nil
+}
+
+func totpStep(t time.Time, opts OTPOptions) uint64 {
+	return uint64(t.Unix()) / uint64(opts.Period/time.Second)
+}
+
+// hotp implements RFC 4226, 5.3.
+func hotp(key []byte, counter uint64, opts OTPOptions) string {
+	h, _ := opts.hash()
+	mac := hmac.New(h, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < opts.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", opts.Digits, code%mod)
+}
+
+func otpEqual(expected, code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1
+}
+
+// recoveryCodeAlphabet leaves out characters easily confused with others.
+const recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// GenerateRecoveryCodes returns n single-use recovery codes, to be shown to
+// the user once, and their hashes, to be stored. Codes are 10 characters,
+// about 50 bits, written as two groups of 5 separated by a dash.
+func GenerateRecoveryCodes(n int) ([]string, []string, error) {
+	return GenerateRecoveryCodesWithParams(n, DefaultPasswordParams)
+}
+
+// GenerateRecoveryCodesWithParams is GenerateRecoveryCodes, hashing codes
+// with params.
+func GenerateRecoveryCodesWithParams(n int, params PasswordParams) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, err := HashPasswordWithParams(normalizeRecoveryCode(code), params)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "hash recovery code")
+		}
+		codes[i] = code
+		hashes[i] = hash
+	}
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode checks code against the stored hashes, ignoring case,
+// spaces and dashes. It returns the index of the matching hash, which must be
+// removed so that the code cannot be used again, or ErrInvalidOTP.
+func VerifyRecoveryCode(code string, hashes []string) (int, error) {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return -1, ErrInvalidOTP
+	}
+	for i, hash := range hashes {
+		ok, err := VerifyPassword(code, hash)
+		if err != nil {
+			return -1, errors.Wrap(err, "verify recovery code")
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, ErrInvalidOTP
+}
+
+func generateRecoveryCode() (string, error) {
+	code, err := randomString(recoveryCodeAlphabet, 10)
+	if err != nil {
+		return "", err
+	}
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
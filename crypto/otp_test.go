@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226, appendix D
+	secret := otpEncoding.EncodeToString([]byte("12345678901234567890"))
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		actual, err := GenerateHOTP(secret, uint64(counter), OTPOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != code {
+			t.Errorf("counter %d: expected %s, got %s", counter, code, actual)
+		}
+	}
+
+	next, err := VerifyHOTP(secret, "969429", 2, OTPOptions{Skew: 1})
+	if err != nil || next != 4 {
+		t.Errorf("unexpected verification result %d, %v", next, err)
+	}
+	if _, err := VerifyHOTP(secret, "338314", 2, OTPOptions{Skew: 1}); err != ErrInvalidOTP {
+		t.Errorf("expected ErrInvalidOTP, got %v", err)
+	}
+	if _, err := VerifyHOTP(secret, "755224", 1, OTPOptions{Skew: 1}); err != ErrInvalidOTP {
+		t.Errorf("expected ErrInvalidOTP for a used counter, got %v", err)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238, appendix B
+	seeds := map[string]string{
+		OTPAlgorithmSHA1:   "12345678901234567890",
+		OTPAlgorithmSHA256: "12345678901234567890123456789012",
+		OTPAlgorithmSHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		unix      int64
+		algorithm string
+		code      string
+	}{
+		{59, OTPAlgorithmSHA1, "94287082"},
+		{59, OTPAlgorithmSHA256, "46119246"},
+		{59, OTPAlgorithmSHA512, "90693936"},
+		{1111111109, OTPAlgorithmSHA1, "07081804"},
+		{1111111109, OTPAlgorithmSHA256, "68084774"},
+		{1111111109, OTPAlgorithmSHA512, "25091201"},
+		{1111111111, OTPAlgorithmSHA1, "14050471"},
+		{1111111111, OTPAlgorithmSHA256, "67062674"},
+		{1111111111, OTPAlgorithmSHA512, "99943326"},
+		{1234567890, OTPAlgorithmSHA1, "89005924"},
+		{1234567890, OTPAlgorithmSHA256, "91819424"},
+		{1234567890, OTPAlgorithmSHA512, "93441116"},
+		{2000000000, OTPAlgorithmSHA1, "69279037"},
+		{2000000000, OTPAlgorithmSHA256, "90698825"},
+		{2000000000, OTPAlgorithmSHA512, "38618901"},
+		{20000000000, OTPAlgorithmSHA1, "65353130"},
+		{20000000000, OTPAlgorithmSHA256, "77737706"},
+		{20000000000, OTPAlgorithmSHA512, "47863826"},
+	}
+	for _, test := range tests {
+		secret := otpEncoding.EncodeToString([]byte(seeds[test.algorithm]))
+		opts := OTPOptions{Algorithm: test.algorithm, Digits: 8}
+		at := time.Unix(test.unix, 0)
+
+		code, err := GenerateTOTP(secret, at, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("%s at %d: expected %s, got %s", test.algorithm, test.unix, test.code, code)
+		}
+		step, err := VerifyTOTP(secret, test.code, at, opts)
+		if err != nil || step != uint64(test.unix/30) {
+			t.Errorf("%s at %d: unexpected verification result %d, %v", test.algorithm, test.unix, step, err)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	secret, err := GenerateOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := GenerateTOTP(secret, now, OTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 6 {
+		t.Errorf("unexpected code %s", code)
+	}
+
+	late := now.Add(30 * time.Second)
+	if _, err := VerifyTOTP(secret, code, late, OTPOptions{}); err != ErrInvalidOTP {
+		t.Errorf("expected ErrInvalidOTP without skew, got %v", err)
+	}
+	step, err := VerifyTOTP(secret, code, late, OTPOptions{Skew: 1})
+	if err != nil || step != uint64(now.Unix()/30) {
+		t.Errorf("unexpected verification result %d, %v", step, err)
+	}
+	if _, err := VerifyTOTP(secret, code, now.Add(90*time.Second), OTPOptions{Skew: 1}); err != ErrInvalidOTP {
+		t.Errorf("expected ErrInvalidOTP outside the window, got %v", err)
+	}
+
+	if _, err := VerifyTOTP("not base32!", code, now, OTPOptions{}); err != ErrInvalidOTPSecret {
+		t.Errorf("expected ErrInvalidOTPSecret, got %v", err)
+	}
+	if _, err := GenerateTOTP(secret, now, OTPOptions{Digits: 10}); err == nil {
+		t.Error("expected an error for 10 digits")
+	}
+}
+
+func TestOTPProvisioningURI(t *testing.T) {
+	uri, err := TOTPProvisioningURI("Replicated Vendor", "alice@example.com", "JBSWY3DPEHPK3PXP", OTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "otpauth://totp/Replicated%20Vendor:alice@example.com?algorithm=SHA1&digits=6&issuer=Replicated%20Vendor&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != expected {
+		t.Errorf("expected %s, got %s", expected, uri)
+	}
+
+	uri, err = HOTPProvisioningURI("", "alice", "JBSWY3DPEHPK3PXP", 7, OTPOptions{Digits: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "otpauth://hotp/alice?algorithm=SHA1&counter=7&digits=8&secret=JBSWY3DPEHPK3PXP"
+	if uri != expected {
+		t.Errorf("expected %s, got %s", expected, uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	params := PasswordParams{Algorithm: PasswordAlgorithmArgon2id, Argon2Time: 1, Argon2Memory: 1024, Argon2Threads: 1}
+	codes, hashes, err := GenerateRecoveryCodesWithParams(4, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 4 || len(hashes) != 4 {
+		t.Fatalf("expected 4 codes, got %d and %d hashes", len(codes), len(hashes))
+	}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected code %s", code)
+		}
+		if strings.Contains(hashes[i], code) {
+			t.Errorf("hash %s contains code", hashes[i])
+		}
+	}
+
+	i, err := VerifyRecoveryCode(strings.ToUpper(strings.Replace(codes[2], "-", " ", 1)), hashes)
+	if err != nil || i != 2 {
+		t.Errorf("unexpected verification result %d, %v", i, err)
+	}
+	if _, err := VerifyRecoveryCode("aaaaa-aaaaa", hashes); err != ErrInvalidOTP {
+		t.Errorf("expected ErrInvalidOTP, got %v", err)
+	}
+}
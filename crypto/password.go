@@ -0,0 +1,184 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	// ErrInvalidPasswordHash is returned for encoded hashes that were not
+	// written by HashPassword.
+	ErrInvalidPasswordHash = errors.New("invalid password hash")
+	// ErrPasswordTooLong is returned when hashing a password longer than 72
+	// bytes with bcrypt.
+	ErrPasswordTooLong = errors.New("password too long for bcrypt")
+)
+
+// PasswordParams select the algorithm and cost of password hashes.
+type PasswordParams struct {
+	// Algorithm is PasswordAlgorithmArgon2id or PasswordAlgorithmBcrypt.
+	Algorithm string
+
+	// Argon2Time is the number of passes over the memory.
+	Argon2Time uint32
+	// Argon2Memory is the memory used in KiB.
+	Argon2Memory uint32
+	// Argon2Threads is the degree of parallelism.
+	Argon2Threads uint8
+
+	// BcryptCost is the bcrypt cost. bcrypt cannot hash passwords longer
+	// than 72 bytes, for which hashing fails with ErrPasswordTooLong; when
+	// rehashing into bcrypt after NeedsRehash, keep the existing hash for
+	// them.
+	BcryptCost int
+}
+
+// DefaultPasswordParams are the parameters used by HashPassword and
+// NeedsRehash. They follow the second recommendation of RFC 9106.
+var DefaultPasswordParams = PasswordParams{
+	Algorithm:     PasswordAlgorithmArgon2id,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 4,
+	BcryptCost:    12,
+}
+
+const (
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+
+	bcryptMaxPasswordLength = 72
+)
+
+// HashPassword hashes password with DefaultPasswordParams. The result encodes
+// the algorithm and its parameters, in the PHC string format for argon2id
+// and the modular crypt format for bcrypt.
+func HashPassword(password string) (string, error) {
+	return HashPasswordWithParams(password, DefaultPasswordParams)
+}
+
+// HashPasswordWithParams hashes password with params.
+func HashPasswordWithParams(password string, params PasswordParams) (string, error) {
+	switch params.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		salt := make([]byte, argon2SaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, argon2KeySize)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, params.Argon2Memory, params.Argon2Time, params.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case PasswordAlgorithmBcrypt:
+		if len(password) > bcryptMaxPasswordLength {
+			return "", ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", errors.Errorf("unsupported password algorithm %q", params.Algorithm)
+}
+
+// VerifyPassword reports whether password matches an encoded hash written
+// by HashPassword with any parameters. The comparison takes constant time.
+// An error is only returned for hashes that cannot be decoded.
+func VerifyPassword(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2Hash(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case isBcryptHash(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		if err != nil {
+			return false, ErrInvalidPasswordHash
+		}
+		return true, nil
+	}
+	return false, ErrInvalidPasswordHash
+}
+
+// NeedsRehash reports whether an encoded hash was not written with
+// DefaultPasswordParams, in which case the password should be hashed again
+// after it was verified.
+func NeedsRehash(encoded string) bool {
+	return NeedsRehashWithParams(encoded, DefaultPasswordParams)
+}
+
+// NeedsRehashWithParams reports whether an encoded hash was not written with
+// params.
+func NeedsRehashWithParams(encoded string, params PasswordParams) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hashParams, salt, key, err := decodeArgon2Hash(encoded)
+		return err != nil ||
+			params.Algorithm != PasswordAlgorithmArgon2id ||
+			hashParams.Argon2Time != params.Argon2Time ||
+			hashParams.Argon2Memory != params.Argon2Memory ||
+			hashParams.Argon2Threads != params.Argon2Threads ||
+			len(salt) != argon2SaltSize ||
+			len(key) != argon2KeySize
+	case isBcryptHash(encoded):
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil ||
+			params.Algorithm != PasswordAlgorithmBcrypt ||
+			cost != params.BcryptCost
+	}
+	return true
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// decodeArgon2Hash parses $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+func decodeArgon2Hash(encoded string) (PasswordParams, []byte, []byte, error) {
+	params := PasswordParams{Algorithm: PasswordAlgorithmArgon2id}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads); err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if params.Argon2Time == 0 || params.Argon2Threads == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, params := range []PasswordParams{
+		DefaultPasswordParams,
+		{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MinCost},
+	} {
+		hash, err := HashPasswordWithParams("hunter2", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := HashPasswordWithParams("hunter2", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash == other {
+			t.Errorf("%s: hashes are not salted", params.Algorithm)
+		}
+
+		if ok, err := VerifyPassword("hunter2", hash); !ok || err != nil {
+			t.Errorf("%s: password does not verify: %v", params.Algorithm, err)
+		}
+		if ok, err := VerifyPassword("hunter3", hash); ok || err != nil {
+			t.Errorf("%s: wrong password verifies: %v", params.Algorithm, err)
+		}
+		if NeedsRehashWithParams(hash, params) {
+			t.Errorf("%s: hash with current parameters needs rehash", params.Algorithm)
+		}
+	}
+
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("unexpected encoding %s", hash)
+	}
+}
+
+func TestHashLongPassword(t *testing.T) {
+	long := strings.Repeat("x", 73)
+	if _, err := HashPasswordWithParams(long, PasswordParams{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MinCost}); err != ErrPasswordTooLong {
+		t.Errorf("expected ErrPasswordTooLong, got %v", err)
+	}
+
+	hash, err := HashPassword(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyPassword(long, hash); err != nil || !ok {
+		t.Errorf("long password did not verify: %v", err)
+	}
+	if ok, _ := VerifyPassword(long[:72], hash); ok {
+		t.Error("truncated password verified")
+	}
+}
+
+func TestVerifyPasswordKnownHashes(t *testing.T) {
+	// written before golang.org/x/crypto was upgraded, to catch changes in
+	// its argon2 and bcrypt packages
+	for _, hash := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$9y6PiBqcq7x6nfAdYS4tOw$yfFQYK6O+jixroDINhduMvllCWyO3q7XabZtIbjhl0Y",
+		"$2a$04$h50uN33S/E3HgQJ0cq3VuuPp.eKsiHLL0alWFaEyX2vdyFpqDuyeG",
+	} {
+		if ok, err := VerifyPassword("hunter2", hash); err != nil || !ok {
+			t.Errorf("%s did not verify: %v", hash, err)
+		}
+		if ok, _ := VerifyPassword("hunter3", hash); ok {
+			t.Errorf("%s verified the wrong password", hash)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := HashPasswordWithParams("hunter2", PasswordParams{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak := DefaultPasswordParams
+	weak.Argon2Time = 1
+	weakHash, err := HashPasswordWithParams("hunter2", weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{bcryptHash, weakHash, "garbage"} {
+		if !NeedsRehash(hash) {
+			t.Errorf("%s does not need rehash", hash)
+		}
+	}
+	// old hashes still verify so that they can be upgraded on login
+	if ok, err := VerifyPassword("hunter2", weakHash); !ok || err != nil {
+		t.Errorf("old hash does not verify: %v", err)
+	}
+}
+
+func TestVerifyPasswordInvalidHash(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=18$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+		"$2a$10$short",
+	} {
+		if _, err := VerifyPassword("hunter2", hash); err != ErrInvalidPasswordHash {
+			t.Errorf("%q: %v", hash, err)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package crypto
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 type PGPKeyPair struct {
@@ -16,56 +20,96 @@ type PGPKeyPair struct {
 	PublicKeyText  string
 }
 
+const (
+	PGPKeyAlgorithmRSA     = "rsa"
+	PGPKeyAlgorithmEd25519 = "ed25519"
+)
+
+// PGPKeyOptions configure GeneratePGPKeyPairWithOptions.
+type PGPKeyOptions struct {
+	// Passphrase, if set, encrypts the private key. Use UnlockPGPPrivateKey
+	// to load it.
+	Passphrase string
+	// Algorithm is PGPKeyAlgorithmRSA or PGPKeyAlgorithmEd25519, which
+	// creates an Ed25519 signing key with a Curve25519 encryption subkey.
+	// Defaults to PGPKeyAlgorithmRSA.
+	Algorithm string
+	// RSABits is the size of RSA primary keys and encryption subkeys, at
+	// least 2048. Defaults to 2048.
+	RSABits int
+	// Lifetime is the time after which the key expires. Zero means the key
+	// does not expire.
+	Lifetime time.Duration
+}
+
 func GeneratePGPKeyPair(name, comment, email string) (*PGPKeyPair, error) {
+	return GeneratePGPKeyPairWithOptions(name, comment, email, PGPKeyOptions{})
+}
+
+// GeneratePGPKeyPairWithOptions generates an armored key pair with a primary
+// signing key and an encryption subkey.
+func GeneratePGPKeyPairWithOptions(name, comment, email string, opts PGPKeyOptions) (*PGPKeyPair, error) {
 	name = makeSafe(name)
 	comment = makeSafe(comment)
 	email = makeSafe(email)
 
-	// ent type is *openpgp.Entity
-	ent, err := openpgp.NewEntity(name, comment, email, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "create new entity")
+	config := &packet.Config{
+		KeyLifetimeSecs: uint32(opts.Lifetime / time.Second),
 	}
-
-	for _, id := range ent.Identities {
-		err := id.SelfSignature.SignUserId(id.UserId.Id, ent.PrimaryKey, ent.PrivateKey, nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "sign user ID")
+	switch opts.Algorithm {
+	case "", PGPKeyAlgorithmRSA:
+		if opts.RSABits == 0 {
+			opts.RSABits = 2048
 		}
+		if opts.RSABits < 2048 {
+			return nil, errors.Errorf("RSA keys must be at least 2048 bits, got %d", opts.RSABits)
+		}
+		config.Algorithm = packet.PubKeyAlgoRSA
+		config.RSABits = opts.RSABits
+	case PGPKeyAlgorithmEd25519:
+		config.Algorithm = packet.PubKeyAlgoEdDSA
+		config.Curve = packet.Curve25519
+	default:
+		return nil, errors.Errorf("unsupported PGP key algorithm %q", opts.Algorithm)
 	}
 
-	for _, subkey := range ent.Subkeys {
-		err := subkey.Sig.SignKey(subkey.PublicKey, ent.PrivateKey, nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "sign key")
-		}
+	// ent type is *openpgp.Entity
+	ent, err := openpgp.NewEntity(name, comment, email, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "create new entity")
 	}
 
 	var pubBuff, prvBuff bytes.Buffer
-	pubWriter := bufio.NewWriter(&pubBuff)
-	prvWriter := bufio.NewWriter(&prvBuff)
 
-	prvEncoder, err := armor.Encode(prvWriter, openpgp.PrivateKeyType, nil)
+	prvEncoder, err := armor.Encode(&prvBuff, openpgp.PrivateKeyType, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "encode secret key")
 	}
-	defer prvEncoder.Close()
-	if err := ent.SerializePrivate(prvEncoder, nil); err != nil {
+	if opts.Passphrase != "" {
+		if err := ent.EncryptPrivateKeys([]byte(opts.Passphrase), config); err != nil {
+			return nil, errors.Wrap(err, "encrypt secret key")
+		}
+	}
+	// NewEntity signed the keys already, and encrypted keys cannot sign
+	if err := ent.SerializePrivateWithoutSigning(prvEncoder, config); err != nil {
 		return nil, errors.Wrap(err, "serialize secret key")
 	}
+	// closing writes the armor footer
+	if err := prvEncoder.Close(); err != nil {
+		return nil, errors.Wrap(err, "encode secret key")
+	}
 
-	pubEncoder, err := armor.Encode(pubWriter, openpgp.PublicKeyType, nil)
+	pubEncoder, err := armor.Encode(&pubBuff, openpgp.PublicKeyType, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "encode public key")
 	}
-	defer pubEncoder.Close()
-
 	if err := ent.Serialize(pubEncoder); err != nil {
 		return nil, errors.Wrap(err, "serialize public key")
 	}
+	if err := pubEncoder.Close(); err != nil {
+		return nil, errors.Wrap(err, "encode public key")
+	}
 
-	_ = pubWriter.Flush()
-	_ = prvWriter.Flush()
 	keyPair := &PGPKeyPair{
 		PrivateKeyText: prvBuff.String(),
 		PublicKeyText:  pubBuff.String(),
@@ -74,6 +118,90 @@ func GeneratePGPKeyPair(name, comment, email string) (*PGPKeyPair, error) {
 	return keyPair, nil
 }
 
+// Fingerprint returns the fingerprint of the primary key, in upper case hex.
+func (k *PGPKeyPair) Fingerprint() (string, error) {
+	ent, err := k.entity()
+	if err != nil {
+		return "", err
+	}
+	return pgpFingerprint(ent), nil
+}
+
+// KeyID returns the long key ID of the primary key, in upper case hex.
+func (k *PGPKeyPair) KeyID() (string, error) {
+	ent, err := k.entity()
+	if err != nil {
+		return "", err
+	}
+	return pgpKeyID(ent), nil
+}
+
+func (k *PGPKeyPair) entity() (*openpgp.Entity, error) {
+	keyring, err := readPGPKeys(k.PublicKeyText)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) != 1 {
+		return nil, errors.New("expected a single public key")
+	}
+	return keyring[0], nil
+}
+
+// PGPPrivateKey is an unlocked private key.
+type PGPPrivateKey struct {
+	entity *openpgp.Entity
+}
+
+// UnlockPGPPrivateKey reads an armored private key, decrypting it with
+// passphrase if it is encrypted.
+func UnlockPGPPrivateKey(privateKey, passphrase string) (*PGPPrivateKey, error) {
+	keyring, err := readPGPKeys(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) != 1 || keyring[0].PrivateKey == nil {
+		return nil, errors.New("expected a single private key")
+	}
+	ent := keyring[0]
+
+	keys := []*packet.PrivateKey{ent.PrivateKey}
+	for _, subkey := range ent.Subkeys {
+		if subkey.PrivateKey != nil {
+			keys = append(keys, subkey.PrivateKey)
+		}
+	}
+	for _, key := range keys {
+		if !key.Encrypted {
+			continue
+		}
+		if passphrase == "" {
+			return nil, errors.New("private key is encrypted, passphrase required")
+		}
+		if err := key.Decrypt([]byte(passphrase)); err != nil {
+			return nil, errors.Wrap(err, "decrypt private key")
+		}
+	}
+	return &PGPPrivateKey{entity: ent}, nil
+}
+
+// Fingerprint returns the fingerprint of the primary key, in upper case hex.
+func (k *PGPPrivateKey) Fingerprint() string {
+	return pgpFingerprint(k.entity)
+}
+
+// KeyID returns the long key ID of the primary key, in upper case hex.
+func (k *PGPPrivateKey) KeyID() string {
+	return pgpKeyID(k.entity)
+}
+
+func pgpFingerprint(ent *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(ent.PrimaryKey.Fingerprint[:]))
+}
+
+func pgpKeyID(ent *openpgp.Entity) string {
+	return fmt.Sprintf("%016X", ent.PrimaryKey.KeyId)
+}
+
 // From the openpgp package source:
 // NewUserId returns a UserId or nil if any of the arguments contain invalid
 // characters. The invalid characters are '\x00', '(', ')', '<' and '>'
@@ -0,0 +1,210 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+)
+
+// PGPSigner identifies the key a verified signature was made with.
+type PGPSigner struct {
+	Name    string
+	Comment string
+	Email   string
+	// KeyID is the long key ID of the primary key, in upper case hex.
+	KeyID string
+	// Fingerprint is the fingerprint of the primary key, in upper case hex.
+	Fingerprint string
+}
+
+// PGPEncrypt encrypts plaintext to one or more armored public keys and
+// returns an armored message that any of their private keys can decrypt.
+func PGPEncrypt(plaintext []byte, publicKeys ...string) (string, error) {
+	if len(publicKeys) == 0 {
+		return "", errors.New("no public keys")
+	}
+	to, err := readPGPKeys(publicKeys...)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	armored, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", errors.Wrap(err, "encode message")
+	}
+	w, err := openpgp.Encrypt(armored, to, nil, nil, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "encrypt message")
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return "", errors.Wrap(err, "encrypt message")
+	}
+	if err := w.Close(); err != nil {
+		return "", errors.Wrap(err, "encrypt message")
+	}
+	if err := armored.Close(); err != nil {
+		return "", errors.Wrap(err, "encode message")
+	}
+	return buf.String(), nil
+}
+
+// PGPDecrypt decrypts an armored message with an unencrypted armored private
+// key.
+func PGPDecrypt(ciphertext string, privateKey string) ([]byte, error) {
+	key, err := UnlockPGPPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, err
+	}
+	return key.Decrypt(ciphertext)
+}
+
+// Decrypt decrypts an armored message. A message that is not encrypted is
+// rejected rather than returned as is.
+func (k *PGPPrivateKey) Decrypt(ciphertext string) ([]byte, error) {
+	block, err := armor.Decode(strings.NewReader(ciphertext))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode message")
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{k.entity}, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt message")
+	}
+	if !md.IsEncrypted {
+		return nil, errors.New("message is not encrypted")
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt message")
+	}
+	return plaintext, nil
+}
+
+// PGPSignDetached returns an armored detached signature of data made with an
+// unencrypted armored private key.
+func PGPSignDetached(data []byte, privateKey string) (string, error) {
+	key, err := UnlockPGPPrivateKey(privateKey, "")
+	if err != nil {
+		return "", err
+	}
+	return key.SignDetached(data)
+}
+
+// SignDetached returns an armored detached signature of data.
+func (k *PGPPrivateKey) SignDetached(data []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, k.entity, bytes.NewReader(data), nil); err != nil {
+		return "", errors.Wrap(err, "sign")
+	}
+	return buf.String(), nil
+}
+
+// PGPVerifyDetached checks an armored detached signature of data against one
+// or more armored public keys and returns the identity of the signer.
+func PGPVerifyDetached(data []byte, signature string, publicKeys ...string) (*PGPSigner, error) {
+	keyring, err := readPGPKeys(publicKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), strings.NewReader(signature), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify signature")
+	}
+	return newPGPSigner(signer), nil
+}
+
+// PGPClearSign returns data as a clear-text signed message made with an
+// unencrypted armored private key.
+func PGPClearSign(data []byte, privateKey string) (string, error) {
+	key, err := UnlockPGPPrivateKey(privateKey, "")
+	if err != nil {
+		return "", err
+	}
+	return key.ClearSign(data)
+}
+
+// ClearSign returns data as a clear-text signed message.
+func (k *PGPPrivateKey) ClearSign(data []byte) (string, error) {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, k.entity.PrivateKey, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "sign")
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", errors.Wrap(err, "sign")
+	}
+	if err := w.Close(); err != nil {
+		return "", errors.Wrap(err, "sign")
+	}
+	return buf.String(), nil
+}
+
+// PGPVerifyClearSigned checks a clear-text signed message against one or more
+// armored public keys and returns its content and the identity of the signer.
+func PGPVerifyClearSigned(message string, publicKeys ...string) ([]byte, *PGPSigner, error) {
+	keyring, err := readPGPKeys(publicKeys...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := clearsign.Decode([]byte(message))
+	if block == nil {
+		return nil, nil, errors.New("no clear-signed message found")
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "verify signature")
+	}
+	return block.Plaintext, newPGPSigner(signer), nil
+}
+
+// readPGPKeys reads armored keys into a keyring.
+func readPGPKeys(armoredKeys ...string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, armored := range armoredKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+		if err != nil {
+			return nil, errors.Wrap(err, "read key")
+		}
+		keyring = append(keyring, entities...)
+	}
+	return keyring, nil
+}
+
+func newPGPSigner(entity *openpgp.Entity) *PGPSigner {
+	signer := &PGPSigner{
+		KeyID:       pgpKeyID(entity),
+		Fingerprint: pgpFingerprint(entity),
+	}
+	if id := primaryPGPIdentity(entity); id != nil {
+		signer.Name = id.UserId.Name
+		signer.Comment = id.UserId.Comment
+		signer.Email = id.UserId.Email
+	}
+	return signer
+}
+
+// primaryPGPIdentity returns the identity flagged as primary, or the first
+// one by name.
+func primaryPGPIdentity(entity *openpgp.Entity) *openpgp.Identity {
+	names := make([]string, 0, len(entity.Identities))
+	for name, id := range entity.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			return id
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return entity.Identities[names[0]]
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func TestPGPEncryptDecrypt(t *testing.T) {
+	alice, err := GeneratePGPKeyPair("Alice", "", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := GeneratePGPKeyPair("Bob", "", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(alice.PublicKeyText), "-----END PGP PUBLIC KEY BLOCK-----") {
+		t.Errorf("public key is not terminated: %q", alice.PublicKeyText)
+	}
+
+	ciphertext, err := PGPEncrypt([]byte("license"), alice.PublicKeyText, bob.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kp := range []*PGPKeyPair{alice, bob} {
+		plaintext, err := PGPDecrypt(ciphertext, kp.PrivateKeyText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != "license" {
+			t.Errorf("%q != %q", plaintext, "license")
+		}
+	}
+
+	onlyAlice, err := PGPEncrypt([]byte("license"), alice.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PGPDecrypt(onlyAlice, bob.PrivateKeyText); err == nil {
+		t.Error("decrypted with a key the message was not encrypted to")
+	}
+}
+
+func TestPGPDecryptPlaintextMessage(t *testing.T) {
+	kp, err := GeneratePGPKeyPair("Alice", "", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	armored, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := packet.SerializeLiteral(armored, true, "", uint32(time.Now().Unix()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("license")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armored.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PGPDecrypt(buf.String(), kp.PrivateKeyText); err == nil {
+		t.Error("decrypted a message that is not encrypted")
+	}
+}
+
+func TestPGPSignVerify(t *testing.T) {
+	signer, err := GeneratePGPKeyPair("Vendor", "releases", "releases@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GeneratePGPKeyPair("Other", "", "other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("bundle contents\n")
+
+	signature, err := PGPSignDetached(data, signer.PrivateKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := PGPVerifyDetached(data, signature, other.PublicKeyText, signer.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "Vendor" || identity.Comment != "releases" || identity.Email != "releases@example.com" {
+		t.Errorf("unexpected signer %+v", identity)
+	}
+	if len(identity.KeyID) != 16 || len(identity.Fingerprint) != 40 || !strings.HasSuffix(identity.Fingerprint, identity.KeyID) {
+		t.Errorf("unexpected key ID %s or fingerprint %s", identity.KeyID, identity.Fingerprint)
+	}
+	if _, err := PGPVerifyDetached([]byte("tampered"), signature, signer.PublicKeyText); err == nil {
+		t.Error("verified signature of tampered data")
+	}
+	if _, err := PGPVerifyDetached(data, signature, other.PublicKeyText); err == nil {
+		t.Error("verified signature with unknown key")
+	}
+
+	message, err := PGPClearSign(data, signer.PrivateKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message, "bundle contents") {
+		t.Errorf("clear-signed message does not contain the data: %q", message)
+	}
+	plaintext, identity, err := PGPVerifyClearSigned(message, signer.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "bundle contents\n" || identity.Email != "releases@example.com" {
+		t.Errorf("unexpected plaintext %q or signer %+v", plaintext, identity)
+	}
+	tampered := strings.Replace(message, "bundle contents", "bundle content", 1)
+	if _, _, err := PGPVerifyClearSigned(tampered, signer.PublicKeyText); err == nil {
+		t.Error("verified tampered clear-signed message")
+	}
+}
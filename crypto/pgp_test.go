@@ -1,6 +1,12 @@
 package crypto
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
 
 func TestMakeSafe(t *testing.T) {
 	if s := makeSafe("safestring"); s != "safestring" {
@@ -11,3 +17,126 @@ func TestMakeSafe(t *testing.T) {
 		t.Errorf("%q != %q", s, "not-safe-----")
 	}
 }
+
+func TestGeneratePGPKeyPairWithOptions(t *testing.T) {
+	kp, err := GeneratePGPKeyPairWithOptions("Vendor", "", "vendor@example.com", PGPKeyOptions{
+		Passphrase: "correct horse",
+		RSABits:    3072,
+		Lifetime:   365 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnlockPGPPrivateKey(kp.PrivateKeyText, ""); err == nil {
+		t.Error("unlocked encrypted key without passphrase")
+	}
+	if _, err := UnlockPGPPrivateKey(kp.PrivateKeyText, "wrong"); err == nil {
+		t.Error("unlocked encrypted key with wrong passphrase")
+	}
+	if _, err := PGPSignDetached([]byte("data"), kp.PrivateKeyText); err == nil {
+		t.Error("signed with encrypted key")
+	}
+	key, err := UnlockPGPPrivateKey(kp.PrivateKeyText, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint, err := kp.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := kp.KeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint != key.Fingerprint() || keyID != key.KeyID() || !strings.HasSuffix(fingerprint, keyID) {
+		t.Errorf("fingerprint %s or key ID %s do not match the private key", fingerprint, keyID)
+	}
+
+	ciphertext, err := PGPEncrypt([]byte("license"), kp.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := key.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "license" {
+		t.Errorf("%q != %q", plaintext, "license")
+	}
+	signature, err := key.SignDetached([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PGPVerifyDetached([]byte("data"), signature, kp.PublicKeyText); err != nil {
+		t.Error(err)
+	}
+
+	ent, err := kp.entity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits, _ := ent.PrimaryKey.BitLength(); bits != 3072 {
+		t.Errorf("key has %d bits", bits)
+	}
+	for _, id := range ent.Identities {
+		if lifetime := id.SelfSignature.KeyLifetimeSecs; lifetime == nil || *lifetime != 365*24*60*60 {
+			t.Errorf("unexpected key lifetime %v", lifetime)
+		}
+	}
+
+	if _, err := GeneratePGPKeyPairWithOptions("Vendor", "", "vendor@example.com", PGPKeyOptions{RSABits: 1024}); err == nil {
+		t.Error("generated 1024 bit key")
+	}
+}
+
+func TestGeneratePGPKeyPairEd25519(t *testing.T) {
+	kp, err := GeneratePGPKeyPairWithOptions("Vendor", "", "vendor@example.com", PGPKeyOptions{
+		Algorithm:  PGPKeyAlgorithmEd25519,
+		Passphrase: "correct horse",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := kp.entity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ent.PrimaryKey.PubKeyAlgo != packet.PubKeyAlgoEdDSA {
+		t.Errorf("primary key algorithm %d", ent.PrimaryKey.PubKeyAlgo)
+	}
+	if len(ent.Subkeys) != 1 || ent.Subkeys[0].PublicKey.PubKeyAlgo != packet.PubKeyAlgoECDH {
+		t.Error("expected a single ECDH subkey")
+	}
+
+	key, err := UnlockPGPPrivateKey(kp.PrivateKeyText, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := PGPEncrypt([]byte("license"), kp.PublicKeyText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := key.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "license" {
+		t.Errorf("%q != %q", plaintext, "license")
+	}
+	signed, err := key.ClearSign([]byte("data\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, signer, err := PGPVerifyClearSigned(signed, kp.PublicKeyText); err != nil {
+		t.Error(err)
+	} else if signer.Fingerprint != key.Fingerprint() {
+		t.Errorf("signed by %s", signer.Fingerprint)
+	}
+
+	if _, err := GeneratePGPKeyPairWithOptions("Vendor", "", "vendor@example.com", PGPKeyOptions{Algorithm: "dsa"}); err == nil {
+		t.Error("generated a key with an unsupported algorithm")
+	}
+}
@@ -0,0 +1,258 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// streamMagic starts every encrypted stream and versions its format.
+	streamMagic = "SKS1"
+	// streamChunkSize is the size of the plaintext of every chunk but the
+	// last one.
+	streamChunkSize = 64 * 1024
+	streamSaltSize  = 32
+)
+
+// ErrTruncated is returned when an encrypted stream ends before its final
+// chunk.
+var ErrTruncated = errors.New("encrypted stream is truncated")
+
+// NewEncryptWriter returns a writer encrypting to w with a 32-byte key. The
+// plaintext is sealed with AES-256-GCM in chunks of 64KiB, using a key derived
+// from key and a random salt per stream. Chunks are numbered and the last one
+// is marked, so that reordered, dropped or truncated chunks fail to decrypt.
+// associatedData is authenticated with every chunk.
+//
+// Close must be called to write the final chunk. It does not close w.
+func NewEncryptWriter(w io.Writer, key, associatedData []byte) (io.WriteCloser, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := newStreamAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, streamMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:     w,
+		aead:  aead,
+		ad:    associatedData,
+		buf:   make([]byte, 0, streamChunkSize),
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+// NewEncryptReader returns a reader of the encryption of r, as written by
+// NewEncryptWriter. It suits APIs that consume a reader, such as S3 uploads.
+// Encryption runs in a goroutine until r is exhausted, so the caller must
+// Close the reader if it stops reading early.
+func NewEncryptReader(r io.Reader, key, associatedData []byte) (io.ReadCloser, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := NewEncryptWriter(pw, key, associatedData)
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	nonce   []byte
+	counter uint64
+	closed  bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, since the
+		// last chunk has to be marked as such
+		if len(e.buf) == streamChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):streamChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	streamNonce(e.nonce, e.counter, last)
+	e.counter++
+	sealed := e.aead.Seal(e.buf[:0:0], e.nonce, e.buf, e.ad)
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// NewDecryptReader returns a reader of the plaintext of a stream written by
+// NewEncryptWriter. Reads fail with ErrDecrypt if the stream was tampered
+// with or reordered, and with ErrTruncated if it ends early. Plaintext is
+// only returned once its chunk has been authenticated, but consumers should
+// still treat everything read as untrusted until the reader returns io.EOF.
+func NewDecryptReader(r io.Reader, key, associatedData []byte) (io.Reader, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	header := make([]byte, len(streamMagic)+streamSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, ErrUnsupportedVersion
+	}
+	aead, err := newStreamAEAD(key, header[len(streamMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:     r,
+		aead:  aead,
+		ad:    associatedData,
+		chunk: make([]byte, streamChunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+type decryptReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	ad        []byte
+	chunk     []byte
+	plaintext []byte
+	nonce     []byte
+	counter   uint64
+	done      bool
+	err       error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			d.err = d.checkEOF()
+			continue
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case err == io.EOF:
+		return ErrTruncated
+	case err == io.ErrUnexpectedEOF:
+		// only the last chunk can be short
+		return d.openChunk(d.chunk[:n], true)
+	case err != nil:
+		return err
+	}
+	// a full chunk may or may not be the last one
+	if err := d.openChunk(d.chunk, false); err == nil {
+		return nil
+	}
+	return d.openChunk(d.chunk, true)
+}
+
+func (d *decryptReader) openChunk(chunk []byte, last bool) error {
+	streamNonce(d.nonce, d.counter, last)
+	plaintext, err := d.aead.Open(d.plaintext[:0], d.nonce, chunk, d.ad)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.counter++
+	d.plaintext = plaintext
+	d.done = last
+	return nil
+}
+
+// checkEOF returns io.EOF if nothing follows the last chunk.
+func (d *decryptReader) checkEOF() error {
+	var b [1]byte
+	n, err := io.ReadFull(d.r, b[:])
+	if n > 0 {
+		return ErrDecrypt
+	}
+	if err == io.EOF {
+		return io.EOF
+	}
+	return err
+}
+
+func newStreamAEAD(key, salt []byte) (cipher.AEAD, error) {
+	streamKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamMagic)), streamKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// streamNonce writes the nonce of a chunk: its big endian counter followed by
+// a byte marking the last chunk.
+func streamNonce(nonce []byte, counter uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
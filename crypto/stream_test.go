@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func encryptStream(t *testing.T, key, plaintext []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd write sizes exercise the chunk boundaries
+	for p := plaintext; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(key, ciphertext []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), key, []byte("ad"))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := decryptStream(key, encryptStream(t, key, plaintext))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("size %d: decrypted plaintext differs", size)
+		}
+	}
+}
+
+func TestStreamEncryptReader(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plaintext := bytes.Repeat([]byte("backup"), streamChunkSize)
+	r, err := NewEncryptReader(bytes.NewReader(plaintext), key, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptStream(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Error("decrypted plaintext differs")
+	}
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestStreamEncryptReaderClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	r, err := NewEncryptReader(endlessReader{}, bytes.Repeat([]byte{1}, 32), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the encrypting goroutine exits once its next write fails
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatal("encrypt goroutine still running after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamKnownCiphertext(t *testing.T) {
+	// written before golang.org/x/crypto was upgraded, to catch changes in
+	// its hkdf package
+	ciphertext, err := base64.StdEncoding.DecodeString("U0tTMZOSI/CazlHaOPncQTjmNRNf+HefAUolTgMsF3P7TX/O02xoDNY8E1WktCGfmZlAoFj3LWoEqA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), bytes.Repeat([]byte{7}, 32), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "stream" {
+		t.Errorf("%q != %q", plaintext, "stream")
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plaintext := bytes.Repeat([]byte{7}, 3*streamChunkSize+10)
+	ciphertext := encryptStream(t, key, plaintext)
+	header := len(streamMagic) + streamSaltSize
+	sealedChunk := streamChunkSize + 16
+
+	chunk := func(i int) []byte {
+		start := header + i*sealedChunk
+		end := start + sealedChunk
+		if end > len(ciphertext) {
+			end = len(ciphertext)
+		}
+		return ciphertext[start:end]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	flipped := append([]byte(nil), ciphertext...)
+	flipped[header+10] ^= 1
+
+	for name, tc := range map[string]struct {
+		ciphertext []byte
+		err        error
+	}{
+		"truncated at chunk boundary": {ciphertext[:header+3*sealedChunk], ErrTruncated},
+		"truncated header":            {ciphertext[:10], ErrTruncated},
+		"truncated mid chunk":         {ciphertext[:header+sealedChunk+100], ErrDecrypt},
+		"reordered":                   {join(ciphertext[:header], chunk(1), chunk(0), chunk(2), chunk(3)), ErrDecrypt},
+		"dropped chunk":               {join(ciphertext[:header], chunk(0), chunk(2), chunk(3)), ErrDecrypt},
+		"trailing data":               {join(ciphertext, []byte{0}), ErrDecrypt},
+		"flipped bit":                 {flipped, ErrDecrypt},
+	} {
+		if _, err := decryptStream(key, tc.ciphertext); err != tc.err {
+			t.Errorf("%s: %v != %v", name, err, tc.err)
+		}
+	}
+
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), key, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != ErrDecrypt {
+		t.Errorf("other associated data: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tokenAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// tokenChecksumLength base62 digits hold any CRC32
+	tokenChecksumLength = 6
+	// defaultTokenLength random base62 characters hold about 178 bits
+	defaultTokenLength = 30
+)
+
+// TokenGenerator generates random tokens of the form
+// <prefix><random><checksum>, using only URL-safe base62 characters after
+// the prefix. The prefix, such as "rpl_", lets secret scanners find leaked
+// tokens and the CRC32 checksum lets them, and ValidToken, reject strings
+// that merely look like tokens without a database lookup.
+type TokenGenerator struct {
+	Prefix string
+	// Length is the number of random characters. Defaults to 30.
+	Length int
+}
+
+// Generate returns a new token.
+func (g TokenGenerator) Generate() (string, error) {
+	length := g.Length
+	if length <= 0 {
+		length = defaultTokenLength
+	}
+
+	random, err := randomString(tokenAlphabet, length)
+	if err != nil {
+		return "", err
+	}
+
+	body := g.Prefix + random
+	return body + tokenChecksum(body), nil
+}
+
+// Valid reports whether token has the prefix, length and checksum of tokens
+// generated by g.
+func (g TokenGenerator) Valid(token string) bool {
+	length := g.Length
+	if length <= 0 {
+		length = defaultTokenLength
+	}
+	if !strings.HasPrefix(token, g.Prefix) || len(token) != len(g.Prefix)+length+tokenChecksumLength {
+		return false
+	}
+	for _, c := range token[len(g.Prefix):] {
+		if !strings.ContainsRune(tokenAlphabet, c) {
+			return false
+		}
+	}
+	body := token[:len(token)-tokenChecksumLength]
+	return subtle.ConstantTimeCompare([]byte(tokenChecksum(body)), []byte(token[len(body):])) == 1
+}
+
+// GenerateToken returns a new token with prefix and the default length.
+func GenerateToken(prefix string) (string, error) {
+	return TokenGenerator{Prefix: prefix}.Generate()
+}
+
+// ValidToken reports whether token was generated by GenerateToken with
+// prefix.
+func ValidToken(token, prefix string) bool {
+	return TokenGenerator{Prefix: prefix}.Valid(token)
+}
+
+func tokenChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	checksum := make([]byte, tokenChecksumLength)
+	for i := tokenChecksumLength - 1; i >= 0; i-- {
+		checksum[i] = tokenAlphabet[sum%62]
+		sum /= 62
+	}
+	return string(checksum)
+}
+
+// HashToken returns the form of a token to store, so that a database leak
+// does not leak usable tokens. Tokens are random enough for a plain SHA-256,
+// which, unlike password hashes, can be looked up by value.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyTokenHash reports whether hash is the HashToken of token, in constant
+// time.
+func VerifyTokenHash(token, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+// randomString returns n characters picked uniformly at random from
+// alphabet, which must be at most 256 characters long.
+func randomString(alphabet string, n int) (string, error) {
+	// bytes at or above limit are rejected, since mapping them onto the
+	// alphabet would favor its first characters
+	limit := 256 - 256%len(alphabet)
+	s := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(s) < n {
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			return "", errors.Wrap(err, "read random")
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(s) < n {
+				s = append(s, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(s), nil
+}
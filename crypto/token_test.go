@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGenerateToken(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		token, err := GenerateToken("rpl_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(token, "rpl_") || len(token) != 4+30+6 {
+			t.Errorf("unexpected token %s", token)
+		}
+		if url.QueryEscape(token) != token {
+			t.Errorf("token %s is not URL-safe", token)
+		}
+		if !ValidToken(token, "rpl_") {
+			t.Errorf("token %s is not valid", token)
+		}
+		if seen[token] {
+			t.Errorf("token %s generated twice", token)
+		}
+		seen[token] = true
+	}
+
+	token, err := GenerateToken("rpl_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typo := []byte(token)
+	if typo[10] == 'a' {
+		typo[10] = 'b'
+	} else {
+		typo[10] = 'a'
+	}
+	for _, invalid := range []string{
+		string(typo),
+		"inv_" + token[4:],
+		token[:len(token)-1],
+		strings.Replace(token, token[5:6], "-", 1),
+	} {
+		if ValidToken(invalid, "rpl_") {
+			t.Errorf("%s is valid", invalid)
+		}
+	}
+
+	g := TokenGenerator{Prefix: "inv_", Length: 8}
+	invite, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invite) != 4+8+6 || !g.Valid(invite) || ValidToken(invite, "inv_") {
+		t.Errorf("unexpected invite code %s", invite)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := GenerateToken("rpl_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := HashToken(token)
+	if strings.Contains(hash, token) || hash != HashToken(token) {
+		t.Errorf("unexpected hash %s", hash)
+	}
+	if !VerifyTokenHash(token, hash) || VerifyTokenHash(token+"x", hash) {
+		t.Error("hash verification failed")
+	}
+}
@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookVerify(t *testing.T) {
+	oldSecret := []byte("old")
+	newSecret := []byte("new")
+	body := []byte(`{"event":"license.updated"}`)
+	now := time.Now()
+
+	v := NewWebhookVerifier(newSecret, oldSecret)
+
+	for name, tc := range map[string]struct {
+		body   []byte
+		header string
+		err    error
+	}{
+		"old secret":         {body, SignWebhook(body, now, oldSecret), nil},
+		"both secrets":       {body, SignWebhook(body, now.Add(-time.Second), newSecret, oldSecret), nil},
+		"unsigned":           {body, "", ErrWebhookUnsigned},
+		"no signature":       {body, "t=123", ErrWebhookUnsigned},
+		"unknown secret":     {body, SignWebhook(body, now, []byte("other")), ErrWebhookSignature},
+		"tampered body":      {[]byte(`{}`), SignWebhook(body, now.Add(-2*time.Second), newSecret), ErrWebhookSignature},
+		"expired":            {body, SignWebhook(body, now.Add(-10*time.Minute), newSecret), ErrWebhookExpired},
+		"from the future":    {body, SignWebhook(body, now.Add(10*time.Minute), newSecret), ErrWebhookExpired},
+		"tampered timestamp": {body, strings.Replace(SignWebhook(body, now.Add(-3*time.Second), newSecret), "t=", "t=1", 1), ErrWebhookExpired},
+	} {
+		if err := v.verify(tc.body, tc.header, now); err != tc.err {
+			t.Errorf("%s: %v != %v", name, err, tc.err)
+		}
+	}
+}
+
+func TestWebhookDefaultTolerance(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{}`)
+	now := time.Now()
+	v := &WebhookVerifier{Secrets: [][]byte{secret}}
+
+	if err := v.verify(body, SignWebhook(body, now.Add(-time.Minute), secret), now); err != nil {
+		t.Errorf("within default tolerance: %v", err)
+	}
+	if err := v.verify(body, SignWebhook(body, now.Add(-10*time.Minute), secret), now); err != ErrWebhookExpired {
+		t.Errorf("outside default tolerance: %v", err)
+	}
+}
+
+func TestWebhookReplay(t *testing.T) {
+	secretA := []byte("a")
+	secretB := []byte("b")
+	body := []byte(`{}`)
+	now := time.Now()
+	v := NewWebhookVerifier(secretA, secretB)
+
+	header := SignWebhook(body, now, secretA, secretB)
+	if err := v.verify(body, header, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.verify(body, header, now); err != ErrWebhookReplayed {
+		t.Errorf("replay: %v", err)
+	}
+	if err := v.verify(body, SignWebhook(body, now, secretB), now); err != ErrWebhookReplayed {
+		t.Errorf("replay with a single signature: %v", err)
+	}
+	// the same body sent again later is a new webhook
+	if err := v.verify(body, SignWebhook(body, now.Add(time.Second), secretA), now); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("secret")
+	r := gin.New()
+	r.POST("/webhook", WebhookMiddleware(NewWebhookVerifier(secret)), func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	send := func(sign bool) *httptest.ResponseRecorder {
+		body := []byte(`{"id":1}`)
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+		if sign {
+			SignWebhookRequest(req, body, secret)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(false); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: %d", w.Code)
+	}
+	w := send(true)
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":1}` {
+		t.Errorf("signed request: %d %q", w.Code, w.Body.String())
+	}
+}
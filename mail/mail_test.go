@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/replicatedcom/saaskit/common"
+	"github.com/replicatedcom/saaskit/param"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendMail(t *testing.T) {
+	t.Setenv("AWS_SQS_MAIL_QUEUENAME", "mail_api")
+	param.Init(nil)
+
+	q := common.NewMemoryQueue()
+	common.SetDefaultQueue(q)
+	defer common.SetDefaultQueue(nil)
+
+	err := SendMail("from@example.com", "From", []string{"to@example.com"}, "welcome", "Welcome", map[string]interface{}{"name": "test"})
+	require.NoError(t, err)
+
+	sent := q.Sent("mail_api")
+	require.Len(t, sent, 1)
+	assert.Equal(t, "send", sent[0].Action)
+
+	var request map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(sent[0].Body), &request))
+	assert.Equal(t, "welcome", request["template"])
+	assert.Equal(t, []interface{}{"to@example.com"}, request["recipients"])
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedcom/saaskit/crypto"
+)
+
+var (
+	columnKeyring   *crypto.Keyring
+	columnKeyringMu sync.RWMutex
+)
+
+// SetColumnKeyring sets the keyring EncryptedString and EncryptedJSON columns
+// are encrypted with. Values written by AesEncrypt before are decrypted with
+// the keyring key with the empty ID.
+func SetColumnKeyring(keyring *crypto.Keyring) {
+	columnKeyringMu.Lock()
+	defer columnKeyringMu.Unlock()
+	columnKeyring = keyring
+}
+
+func getColumnKeyring() (*crypto.Keyring, error) {
+	columnKeyringMu.RLock()
+	defer columnKeyringMu.RUnlock()
+	if columnKeyring == nil {
+		return nil, errors.New("column keyring is not set")
+	}
+	return columnKeyring, nil
+}
+
+// EncryptedString is a string column stored encrypted with the column
+// keyring. NULL scans to the empty string. Values are not bound to their row
+// or column, so a value copied into another EncryptedString column still
+// decrypts; use BoundEncryptedString where that matters.
+type EncryptedString string
+
+// Value encrypts s with the active key of the column keyring.
+func (s EncryptedString) Value() (driver.Value, error) {
+	return encryptColumn([]byte(s), nil)
+}
+
+// Scan decrypts a value written by Value.
+func (s *EncryptedString) Scan(src interface{}) error {
+	plaintext, err := decryptColumn(src, nil)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+// BoundEncryptedString is an EncryptedString bound to Context, such as the
+// table and column name, optionally with the row ID. Context is
+// authenticated along with the value, so a value copied to a column or row
+// with a different context fails to decrypt. Context must be set before
+// scanning, and is not stored.
+type BoundEncryptedString struct {
+	String  string
+	Context string
+}
+
+// Value encrypts String with the active key of the column keyring.
+func (s BoundEncryptedString) Value() (driver.Value, error) {
+	return encryptColumn([]byte(s.String), []byte(s.Context))
+}
+
+// Scan decrypts a value written by Value with the same Context.
+func (s *BoundEncryptedString) Scan(src interface{}) error {
+	plaintext, err := decryptColumn(src, []byte(s.Context))
+	if err != nil {
+		return err
+	}
+	s.String = string(plaintext)
+	return nil
+}
+
+// EncryptedJSON is a column holding Data as JSON, stored encrypted with the
+// column keyring. NULL scans to the zero value of T.
+type EncryptedJSON[T any] struct {
+	Data T
+	// Context, if set, binds values as BoundEncryptedString does. It must be
+	// set before scanning.
+	Context string
+}
+
+// Value marshals Data to JSON and encrypts it with the active key of the
+// column keyring.
+func (j EncryptedJSON[T]) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal column")
+	}
+	return encryptColumn(b, []byte(j.Context))
+}
+
+// Scan decrypts a value written by Value and unmarshals it into Data.
+func (j *EncryptedJSON[T]) Scan(src interface{}) error {
+	var data T
+	plaintext, err := decryptColumn(src, []byte(j.Context))
+	if err != nil {
+		return err
+	}
+	if plaintext != nil {
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			return errors.Wrap(err, "unmarshal column")
+		}
+	}
+	j.Data = data
+	return nil
+}
+
+func encryptColumn(plaintext, associatedData []byte) (driver.Value, error) {
+	keyring, err := getColumnKeyring()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := keyring.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt column")
+	}
+	return ciphertext, nil
+}
+
+// decryptColumn returns nil for NULL.
+func decryptColumn(src interface{}, associatedData []byte) ([]byte, error) {
+	var ciphertext string
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		ciphertext = v
+	case []byte:
+		ciphertext = string(v)
+	default:
+		return nil, fmt.Errorf("cannot scan %T into an encrypted column", src)
+	}
+
+	keyring, err := getColumnKeyring()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := keyring.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt column")
+	}
+	return plaintext, nil
+}
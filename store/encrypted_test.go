@@ -0,0 +1,124 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/replicatedcom/saaskit/crypto"
+)
+
+func TestEncryptedColumns(t *testing.T) {
+	SetColumnKeyring(nil)
+	if _, err := EncryptedString("secret").Value(); err == nil {
+		t.Error("encrypted without a keyring")
+	}
+
+	legacyKey := bytes.Repeat([]byte{1}, 32)
+	keyring, err := crypto.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{2}, 32), "": legacyKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetColumnKeyring(keyring)
+	defer SetColumnKeyring(nil)
+
+	value, err := EncryptedString("secret").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(value.(string), "secret") {
+		t.Errorf("value is not encrypted: %v", value)
+	}
+	var s EncryptedString
+	if err := s.Scan([]byte(value.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if s != "secret" {
+		t.Errorf("%q != %q", s, "secret")
+	}
+
+	// values encrypted by hand before are still read
+	legacy, err := crypto.AesEncrypt(legacyKey, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Scan(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if s != "legacy" {
+		t.Errorf("%q != %q", s, "legacy")
+	}
+	if err := s.Scan(nil); err != nil || s != "" {
+		t.Errorf("scan NULL: %q, %v", s, err)
+	}
+
+	type credentials struct {
+		Token string `json:"token"`
+	}
+	value, err = EncryptedJSON[credentials]{Data: credentials{Token: "abc"}}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j EncryptedJSON[credentials]
+	if err := j.Scan(value); err != nil {
+		t.Fatal(err)
+	}
+	if j.Data.Token != "abc" {
+		t.Errorf("unexpected data %+v", j.Data)
+	}
+	if err := j.Scan(nil); err != nil || j.Data.Token != "" {
+		t.Errorf("scan NULL: %+v, %v", j.Data, err)
+	}
+	if err := j.Scan("v1:k1:tampered"); err == nil {
+		t.Error("scanned tampered value")
+	}
+}
+
+func TestBoundEncryptedColumns(t *testing.T) {
+	keyring, err := crypto.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetColumnKeyring(keyring)
+	defer SetColumnKeyring(nil)
+
+	value, err := BoundEncryptedString{String: "secret", Context: "users.api_token"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := BoundEncryptedString{Context: "users.api_token"}
+	if err := s.Scan(value); err != nil {
+		t.Fatal(err)
+	}
+	if s.String != "secret" {
+		t.Errorf("%q != %q", s.String, "secret")
+	}
+
+	// a value copied to another column does not decrypt
+	other := BoundEncryptedString{Context: "users.password_reset_token"}
+	if err := other.Scan(value); err == nil {
+		t.Error("scanned value bound to another column")
+	}
+	var unbound EncryptedString
+	if err := unbound.Scan(value); err == nil {
+		t.Error("scanned bound value into an unbound column")
+	}
+
+	type credentials struct {
+		Token string `json:"token"`
+	}
+	value, err = EncryptedJSON[credentials]{Data: credentials{Token: "abc"}, Context: "apps.credentials"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := EncryptedJSON[credentials]{Context: "apps.credentials"}
+	if err := j.Scan(value); err != nil {
+		t.Fatal(err)
+	}
+	if j.Data.Token != "abc" {
+		t.Errorf("unexpected data %+v", j.Data)
+	}
+	if err := (&EncryptedJSON[credentials]{Context: "apps.settings"}).Scan(value); err == nil {
+		t.Error("scanned value bound to another column")
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/jackc/pgx/stdlib"
 	"github.com/pkg/errors"
+	"github.com/replicatedcom/saaskit/crypto"
 	"github.com/replicatedcom/saaskit/tracing/datadog"
 )
 
@@ -12,6 +13,8 @@ var timescaleDB *sql.DB
 
 type TimescaleOpts struct {
 	URI string
+	// Keyring, if set, becomes the column keyring of encrypted columns.
+	Keyring *crypto.Keyring
 }
 
 func InitTimescale(opts TimescaleOpts) error {
@@ -26,6 +29,9 @@ func InitTimescale(opts TimescaleOpts) error {
 	}
 
 	timescaleDB = db
+	if opts.Keyring != nil {
+		SetColumnKeyring(opts.Keyring)
+	}
 	return nil
 }
 
@@ -37,6 +37,14 @@ func (f *Finishable) Finish() {
 	}
 }
 
+// FinishWithError finishes a Finishable, marking the span as failed if err is
+// not nil. Safe if nil
+func (f *Finishable) FinishWithError(err error) {
+	if f.toFinish != nil {
+		f.toFinish.Finish(tracer.WithError(err))
+	}
+}
+
 // StartSpanFromGin takes a gin context and returns a wrapped Span plus
 // the span's context. If Datadog APM isn't enabled it simply returns
 // a wrapped Nil, which is safe to Finish() and the gin context's HTTP
@@ -76,6 +84,39 @@ func StartSpanFromContext(ctx context.Context, callStackOffset ...int) (*Finisha
 	}, subCtx
 }
 
+// InjectTextMap writes the span context found in ctx into carrier, so that
+// the trace can be continued in another process with StartSpanFromTextMap.
+// It does nothing if Datadog APM isn't enabled or ctx has no span
+func InjectTextMap(ctx context.Context, carrier map[string]string) {
+	if !datadogEnabled() {
+		return
+	}
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return
+	}
+	_ = tracer.Inject(span.Context(), tracer.TextMapCarrier(carrier))
+}
+
+// StartSpanFromTextMap starts a span continuing the trace written to carrier
+// by InjectTextMap, or a new trace if carrier has none. If Datadog APM isn't
+// enabled it simply returns a wrapped Nil, which is safe to Finish() and ctx
+func StartSpanFromTextMap(ctx context.Context, operationName, resourceName string, carrier map[string]string) (*Finishable, context.Context) {
+	if !datadogEnabled() {
+		return &Finishable{nil}, ctx
+	}
+	opts := []tracer.StartSpanOption{
+		tracer.ResourceName(resourceName),
+	}
+	if spanCtx, err := tracer.Extract(tracer.TextMapCarrier(carrier)); err == nil {
+		opts = append(opts, tracer.ChildOf(spanCtx))
+	}
+	span, subCtx := tracer.StartSpanFromContext(ctx, operationName, opts...)
+	return &Finishable{
+		toFinish: span,
+	}, subCtx
+}
+
 // GinMiddleware wraps gin tracer's middleware
 // which already plays nice when Datadog APM is disabled
 func GinMiddleware(service string) gin.HandlerFunc {